@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// What to do with incoming calls, as set with /call/settings
+type callSettings struct {
+	Reject        bool
+	RejectMessage string
+}
+
+// Call as sent with the Call event
+type callInfo struct {
+	Id        string    `json:"id"`
+	State     string    `json:"state"`
+	From      string    `json:"from"`
+	Creator   string    `json:"creator"`
+	IsVideo   bool      `json:"isVideo"`
+	IsGroup   bool      `json:"isGroup"`
+	Timestamp time.Time `json:"timestamp"`
+	Reason    string    `json:"reason,omitempty"`
+	Rejected  bool      `json:"rejected,omitempty"`
+}
+
+func getCallSettings(db *sql.DB, userID int) (callSettings, error) {
+	var c callSettings
+	err := db.QueryRow("SELECT call_reject,call_reject_message FROM users WHERE id=?", userID).Scan(&c.Reject, &c.RejectMessage)
+	return c, err
+}
+
+// Builds the call info from the call node, which has a video child for video calls
+// and a group-jid attribute for group calls
+func newCallInfo(meta types.BasicCallMeta, state string, node *waBinary.Node) *callInfo {
+	call := &callInfo{
+		Id:        meta.CallID,
+		State:     state,
+		From:      meta.From.ToNonAD().String(),
+		Creator:   meta.CallCreator.ToNonAD().String(),
+		Timestamp: meta.Timestamp,
+	}
+	if node != nil {
+		_, call.IsVideo = node.GetOptionalChildByTag("video")
+		_, call.IsGroup = node.Attrs["group-jid"]
+	}
+	return call
+}
+
+// Rejects a call, whatsmeow has no call support so the reject node is sent directly
+func rejectCall(client *whatsmeow.Client, caller types.JID, callID string) error {
+	if client.Store.ID == nil {
+		return whatsmeow.ErrNotLoggedIn
+	}
+	caller = caller.ToNonAD()
+	return client.DangerousInternals().SendNode(waBinary.Node{
+		Tag:   "call",
+		Attrs: waBinary.Attrs{"id": whatsmeow.GenerateMessageID(), "from": client.Store.ID.ToNonAD(), "to": caller},
+		Content: []waBinary.Node{{
+			Tag:   "reject",
+			Attrs: waBinary.Attrs{"call-id": callID, "call-creator": caller, "count": "0"},
+		}},
+	})
+}
+
+// Applies the call settings of the user to an incoming call. The call is rejected before the
+// webhook is sent, so it is only marked as rejected once the reject was actually sent.
+func (mycli *MyClient) handleIncomingCall(call *callInfo, caller types.JID) {
+	settings, err := getCallSettings(mycli.db, mycli.userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Could not get call settings")
+		return
+	} else if !settings.Reject {
+		return
+	}
+	err = rejectCall(mycli.WAClient, caller, call.Id)
+	if err != nil {
+		log.Error().Err(err).Str("id", call.Id).Str("from", call.From).Msg("Could not reject call")
+		return
+	}
+	log.Info().Str("id", call.Id).Str("from", call.From).Msg("Call rejected")
+	call.Rejected = true
+	if settings.RejectMessage != "" {
+		go mycli.sendCallRejectMessage(caller, settings.RejectMessage)
+	}
+}
+
+// Sends the reply text set for the user to the creator of a rejected call
+func (mycli *MyClient) sendCallRejectMessage(caller types.JID, message string) {
+	recipient := caller.ToNonAD()
+	msg := &waProto.Message{Conversation: proto.String(message)}
+	resp, err := mycli.WAClient.SendMessage(context.Background(), recipient, msg)
+	if err != nil {
+		log.Error().Err(err).Str("to", recipient.String()).Msg("Could not send call reject message")
+		return
+	}
+	storeSentMessage(mycli.db, mycli.userID, mycli.WAClient, recipient, resp.ID, msg, resp.Timestamp)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestNewCallInfo(t *testing.T) {
+	caller := types.NewADJID("5491155553935", 0, 2)
+	group := types.NewJID("120363025246125486", types.GroupServer)
+	meta := types.BasicCallMeta{
+		From:        caller,
+		Timestamp:   time.Unix(1650466148, 0),
+		CallCreator: caller,
+		CallID:      "8A4C7F3E5B2D1A09",
+	}
+
+	tests := []struct {
+		name    string
+		state   string
+		node    *waBinary.Node
+		isVideo bool
+		isGroup bool
+	}{
+		{"voice call", "offer", &waBinary.Node{Tag: "offer", Content: []waBinary.Node{{Tag: "audio"}}}, false, false},
+		{"video call", "offer", &waBinary.Node{Tag: "offer", Content: []waBinary.Node{{Tag: "audio"}, {Tag: "video"}}}, true, false},
+		{"group call", "offer", &waBinary.Node{Tag: "offer", Attrs: waBinary.Attrs{"group-jid": group}, Content: []waBinary.Node{{Tag: "audio"}}}, false, true},
+		{"without node", "terminate", nil, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := newCallInfo(meta, tt.state, tt.node)
+			if call.Id != meta.CallID || call.State != tt.state {
+				t.Errorf("id, state = %q, %q, want %q, %q", call.Id, call.State, meta.CallID, tt.state)
+			}
+			if call.From != "5491155553935@s.whatsapp.net" || call.Creator != "5491155553935@s.whatsapp.net" {
+				t.Errorf("from, creator = %q, %q, want the caller without device", call.From, call.Creator)
+			}
+			if !call.Timestamp.Equal(meta.Timestamp) {
+				t.Errorf("timestamp = %v, want %v", call.Timestamp, meta.Timestamp)
+			}
+			if call.IsVideo != tt.isVideo || call.IsGroup != tt.isGroup {
+				t.Errorf("isVideo, isGroup = %v, %v, want %v, %v", call.IsVideo, call.IsGroup, tt.isVideo, tt.isGroup)
+			}
+		})
+	}
+}
+
+func TestHandleIncomingCall(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE users (id INTEGER NOT NULL PRIMARY KEY, call_reject INTEGER NOT NULL default 0, call_reject_message TEXT NOT NULL default "");
+	INSERT INTO users(id,call_reject) VALUES (1,0),(2,1);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller := types.NewADJID("5491155553935", 0, 2)
+
+	tests := []struct {
+		name   string
+		userID int
+		want   bool
+	}{
+		{"rejecting disabled", 1, false},
+		{"reject not sent while logged out", 2, false},
+		{"unknown user", 3, false},
+	}
+	for _, tt := range tests {
+		// Without a stored device the reject fails with ErrNotLoggedIn before anything is sent
+		mycli := &MyClient{WAClient: &whatsmeow.Client{Store: &store.Device{}}, userID: tt.userID, db: db}
+		call := &callInfo{Id: "8A4C7F3E5B2D1A09", From: caller.ToNonAD().String()}
+		mycli.handleIncomingCall(call, caller)
+		if call.Rejected != tt.want {
+			t.Errorf("%s: rejected = %v, want %v", tt.name, call.Rejected, tt.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"go.mau.fi/whatsmeow/appstate"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Muted until value used for chats muted forever
+const mutedForever = -1
+
+// Conversation as returned by /chat/list
+type chatSummary struct {
+	Jid          string
+	Name         string
+	LastActivity time.Time
+	LastMessage  *storedMessage
+	Unread       int
+	Archived     bool
+	Pinned       bool
+	Muted        bool
+	MutedUntil   *time.Time
+}
+
+// Makes sure a chat exists, returns true if it was just created
+func ensureChat(db *sql.DB, userID int, chat types.JID) (bool, error) {
+	res, err := db.Exec("INSERT OR IGNORE INTO chats(user_id,jid) VALUES(?,?)", userID, chat.ToNonAD().String())
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// Updates activity and unread count of a chat for a new message
+func updateChatForMessage(db *sql.DB, userID int, info *types.MessageInfo) (bool, error) {
+	created, err := ensureChat(db, userID, info.Chat)
+	if err != nil {
+		return false, err
+	}
+	// Replying from this or another device means the chat was read
+	unread := "unread+1"
+	if info.IsFromMe {
+		unread = "0"
+	}
+	_, err = db.Exec(
+		"UPDATE chats SET unread="+unread+",last_activity=MAX(last_activity,?) WHERE user_id=? AND jid=?",
+		info.Timestamp.Unix(), userID, info.Chat.ToNonAD().String(),
+	)
+	if err == nil && !info.IsFromMe && !info.IsGroup && info.PushName != "" {
+		_, err = db.Exec("UPDATE chats SET name=? WHERE user_id=? AND jid=? AND name=''", info.PushName, userID, info.Chat.ToNonAD().String())
+	}
+	return created, err
+}
+
+// Sets the name of a chat
+func setChatName(db *sql.DB, userID int, chat types.JID, name string) error {
+	_, err := db.Exec("UPDATE chats SET name=? WHERE user_id=? AND jid=?", name, userID, chat.ToNonAD().String())
+	return err
+}
+
+// Clears the unread count of a chat
+func markChatRead(db *sql.DB, userID int, chat types.JID) error {
+	_, err := db.Exec("UPDATE chats SET unread=0 WHERE user_id=? AND jid=?", userID, chat.ToNonAD().String())
+	return err
+}
+
+// Stores name, flags and unread count of a conversation from a history sync
+func storeHistoryChat(tx *sql.Tx, userID int, chat types.JID, conv *waProto.Conversation) error {
+	unread := int64(conv.GetUnreadCount())
+	if unread == 0 && conv.GetMarkedAsUnread() {
+		unread = 1
+	}
+	mutedUntil := int64(conv.GetMuteEndTime())
+	if mutedUntil < 0 {
+		mutedUntil = mutedForever
+	}
+	lastActivity := int64(conv.GetConversationTimestamp())
+	if ts := int64(conv.GetLastMsgTimestamp()); ts > lastActivity {
+		lastActivity = ts
+	}
+	name := conv.GetName()
+	if name == "" {
+		name = conv.GetDisplayName()
+	}
+	_, err := tx.Exec(
+		`INSERT INTO chats(user_id,jid,name,unread,archived,pinned,muted_until,last_activity) VALUES(?,?,?,?,?,?,?,?)
+		ON CONFLICT(user_id,jid) DO UPDATE SET name=CASE WHEN excluded.name<>'' THEN excluded.name ELSE chats.name END,unread=excluded.unread,archived=excluded.archived,pinned=excluded.pinned,muted_until=excluded.muted_until,last_activity=MAX(chats.last_activity,excluded.last_activity)`,
+		userID, chat.ToNonAD().String(), name, unread, conv.GetArchived(), conv.GetPinned() > 0, mutedUntil, lastActivity,
+	)
+	return err
+}
+
+// Applies archive, pin, mute and read state changes synced from other devices
+func (mycli *MyClient) applyChatAction(evt *events.AppState) {
+	if len(evt.Index) < 2 {
+		return
+	}
+	chat, err := types.ParseJID(evt.Index[1])
+	if err != nil {
+		return
+	}
+
+	var sqlStmt string
+	var value interface{}
+	switch evt.Index[0] {
+	case appstate.IndexArchive:
+		sqlStmt = "UPDATE chats SET archived=? WHERE user_id=? AND jid=?"
+		value = evt.GetArchiveChatAction().GetArchived()
+	case appstate.IndexPin:
+		sqlStmt = "UPDATE chats SET pinned=? WHERE user_id=? AND jid=?"
+		value = evt.GetPinAction().GetPinned()
+	case appstate.IndexMute:
+		sqlStmt = "UPDATE chats SET muted_until=? WHERE user_id=? AND jid=?"
+		act := evt.GetMuteAction()
+		var mutedUntil int64
+		if act.GetMuted() {
+			mutedUntil = act.GetMuteEndTimestamp() / 1000
+			if act.GetMuteEndTimestamp() < 0 {
+				mutedUntil = mutedForever
+			}
+		}
+		value = mutedUntil
+	case appstate.IndexMarkChatAsRead:
+		if evt.GetMarkChatAsReadAction().GetRead() {
+			sqlStmt = "UPDATE chats SET unread=? WHERE user_id=? AND jid=?"
+			value = 0
+		} else {
+			sqlStmt = "UPDATE chats SET unread=MAX(unread,?) WHERE user_id=? AND jid=?"
+			value = 1
+		}
+	default:
+		return
+	}
+
+	_, err = ensureChat(mycli.db, mycli.userID, chat)
+	if err == nil {
+		_, err = mycli.db.Exec(sqlStmt, value, mycli.userID, chat.ToNonAD().String())
+	}
+	if err != nil {
+		log.Error().Err(err).Str("chat", chat.String()).Str("action", evt.Index[0]).Msg("Could not update chat")
+	}
+}
+
+// Sets the name of a group chat from its group info
+func (mycli *MyClient) updateGroupName(group types.JID) {
+	info, err := mycli.WAClient.GetGroupInfo(group)
+	if err != nil {
+		log.Warn().Err(err).Str("group", group.String()).Msg("Could not get group name")
+		return
+	}
+	err = setChatName(mycli.db, mycli.userID, group, info.Name)
+	if err != nil {
+		log.Error().Err(err).Str("group", group.String()).Msg("Could not update chat")
+	}
+}
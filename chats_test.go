@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestUpdateChatForMessage(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE chats (user_id INTEGER NOT NULL, jid TEXT NOT NULL, name TEXT NOT NULL default "", unread INTEGER NOT NULL default 0, archived INTEGER NOT NULL default 0, pinned INTEGER NOT NULL default 0, muted_until INTEGER NOT NULL default 0, last_activity INTEGER NOT NULL default 0, PRIMARY KEY (user_id, jid));
+	CREATE TABLE messages (user_id INTEGER NOT NULL, id TEXT NOT NULL, chat TEXT NOT NULL, sender TEXT NOT NULL, from_me INTEGER NOT NULL default 0, timestamp INTEGER NOT NULL, type TEXT NOT NULL, text TEXT NOT NULL default "", media TEXT NOT NULL default "", quoted_id TEXT NOT NULL default "", raw BLOB, UNIQUE (user_id, chat, id));`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contact := types.NewADJID("5491155554444", 0, 2)
+	start := time.Date(2023, 6, 21, 12, 0, 0, 0, time.UTC)
+	count := 0
+	message := func(fromMe bool, pushName string, at time.Time) *types.MessageInfo {
+		count++
+		return &types.MessageInfo{
+			MessageSource: types.MessageSource{Chat: contact, Sender: contact, IsFromMe: fromMe},
+			ID:            fmt.Sprintf("3EB0%04d", count),
+			PushName:      pushName,
+			Timestamp:     at,
+		}
+	}
+	text := &waProto.Message{Conversation: proto.String("hi")}
+	reaction := &waProto.Message{ReactionMessage: &waProto.ReactionMessage{Text: proto.String("👍")}}
+
+	steps := []struct {
+		name         string
+		info         *types.MessageInfo
+		msg          *waProto.Message
+		created      bool
+		unread       int
+		chatName     string
+		lastActivity time.Time
+	}{
+		{"first message creates the chat", message(false, "Ana", start), text, true, 1, "Ana", start},
+		{"incoming message is unread", message(false, "Ana B", start.Add(time.Minute)), text, false, 2, "Ana", start.Add(time.Minute)},
+		{"reaction is not chat activity", message(false, "Ana", start.Add(90*time.Second)), reaction, false, 2, "Ana", start.Add(time.Minute)},
+		{"older message keeps last activity", message(false, "", start.Add(-time.Hour)), text, false, 3, "Ana", start.Add(time.Minute)},
+		{"reply marks the chat read", message(true, "Me", start.Add(2*time.Minute)), text, false, 0, "Ana", start.Add(2 * time.Minute)},
+	}
+	for _, step := range steps {
+		created, err := storeChatMessage(db, 1, step.info, step.msg)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		var unread int
+		var name string
+		var lastActivity int64
+		err = db.QueryRow("SELECT unread,name,last_activity FROM chats WHERE user_id=1 AND jid=?", "5491155554444@s.whatsapp.net").Scan(&unread, &name, &lastActivity)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if created != step.created || unread != step.unread || name != step.chatName || lastActivity != step.lastActivity.Unix() {
+			t.Errorf("%s: created %v, unread %d, name %q, last activity %d, want %v, %d, %q, %d",
+				step.name, created, unread, name, lastActivity, step.created, step.unread, step.chatName, step.lastActivity.Unix())
+		}
+	}
+
+	if _, err = storeChatMessage(db, 1, message(false, "Ana", start.Add(3*time.Minute)), text); err != nil {
+		t.Fatal(err)
+	}
+	status := message(false, "Ana", start.Add(4*time.Minute))
+	status.Chat = types.StatusBroadcastJID
+	if _, err = storeChatMessage(db, 1, status, text); err != nil {
+		t.Fatal(err)
+	}
+	if err = markChatRead(db, 1, contact); err != nil {
+		t.Fatal(err)
+	}
+	var chats, unread int
+	db.QueryRow("SELECT COUNT(*),SUM(unread) FROM chats").Scan(&chats, &unread)
+	if chats != 1 || unread != 0 {
+		t.Errorf("%d chats with %d unread, want 1 chat for all devices of the contact, read, and none for status posts", chats, unread)
+	}
+}
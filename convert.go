@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	stickerSize     = 512
+	thumbnailSize   = 72
+	waveformSamples = 64
+	convertTimeout  = 2 * time.Minute
+)
+
+var errNoConverter = errors.New("media conversion is not available, ffmpeg was not found")
+
+// Voice note ready to be sent as PTT audio
+type voiceNote struct {
+	Data     []byte
+	Seconds  uint32
+	Waveform []byte
+}
+
+// Preview of an image or video
+type mediaPreview struct {
+	JpegThumbnail []byte
+	Width         uint32
+	Height        uint32
+	Seconds       uint32
+}
+
+// Converts media to the formats expected by WhatsApp
+type mediaConverter interface {
+	// Converts audio to ogg/opus if needed, and measures its duration and waveform
+	Voice(data []byte, mimetype string) (*voiceNote, error)
+	// Converts an image to a 512x512 webp sticker
+	Sticker(data []byte) ([]byte, error)
+	// Generates a jpeg thumbnail and gets the dimensions of an image or video
+	Preview(data []byte) (*mediaPreview, error)
+}
+
+// Converter in use, selected with the -ffmpeg flag
+var converter mediaConverter = noConverter{}
+
+// Converter used when ffmpeg is not available, every conversion fails
+type noConverter struct{}
+
+func (noConverter) Voice(data []byte, mimetype string) (*voiceNote, error) {
+	return nil, errNoConverter
+}
+
+func (noConverter) Sticker(data []byte) ([]byte, error) {
+	return nil, errNoConverter
+}
+
+func (noConverter) Preview(data []byte) (*mediaPreview, error) {
+	return nil, errNoConverter
+}
+
+// Converts media running ffmpeg and ffprobe
+type ffmpegConverter struct {
+	ffmpeg  string
+	ffprobe string
+}
+
+// Finds ffmpeg and ffprobe, ffprobe is looked up next to ffmpeg first
+func newFFmpegConverter(ffmpeg string) (*ffmpegConverter, error) {
+	ffmpegPath, err := exec.LookPath(ffmpeg)
+	if err != nil {
+		return nil, err
+	}
+	ffprobe := strings.TrimSuffix(ffmpegPath, "ffmpeg") + "ffprobe"
+	ffprobePath, err := exec.LookPath(ffprobe)
+	if err != nil {
+		ffprobePath, err = exec.LookPath("ffprobe")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &ffmpegConverter{ffmpeg: ffmpegPath, ffprobe: ffprobePath}, nil
+}
+
+// Runs a command with data written to a temporary file passed in place of the {input} argument, returning its output
+func (f *ffmpegConverter) run(data []byte, command string, args ...string) ([]byte, error) {
+	input, err := os.CreateTemp("", "wuzapi-convert-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(input.Name())
+	_, err = input.Write(data)
+	if closeErr := input.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, arg := range args {
+		if arg == "{input}" {
+			args[i] = input.Name()
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), convertTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if i := strings.LastIndex(msg, "\n"); i >= 0 {
+			msg = msg[i+1:]
+		}
+		return nil, fmt.Errorf("%s failed: %v: %s", command, err, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// Checks whether data is an ogg stream whose first logical stream is Opus. The first ogg page
+// holds only the codec identification header, which starts with the OpusHead magic for Opus.
+func isOggOpus(data []byte) bool {
+	if len(data) < 27 || string(data[:4]) != "OggS" {
+		return false
+	}
+	// The page header is followed by one byte per segment, then by the packet
+	header := 27 + int(data[26])
+	return len(data) >= header+8 && string(data[header:header+8]) == "OpusHead"
+}
+
+func (f *ffmpegConverter) Voice(data []byte, mimetype string) (*voiceNote, error) {
+	// Ogg may also hold Vorbis or other codecs WhatsApp cannot play, only Opus is sent as is
+	if !isOggOpus(data) {
+		converted, err := f.run(data, f.ffmpeg, "-v", "error", "-i", "{input}", "-vn", "-ac", "1", "-ar", "48000", "-c:a", "libopus", "-b:a", "32k", "-f", "ogg", "pipe:1")
+		if err != nil {
+			return nil, fmt.Errorf("could not convert audio: %v", err)
+		}
+		data = converted
+	}
+
+	// Decodes to 8kHz mono PCM to measure duration and loudness
+	pcm, err := f.run(data, f.ffmpeg, "-v", "error", "-i", "{input}", "-vn", "-ac", "1", "-ar", "8000", "-f", "s16le", "pipe:1")
+	if err != nil {
+		return nil, fmt.Errorf("could not decode audio: %v", err)
+	}
+	samples := make([]int16, len(pcm)/2)
+	binary.Read(bytes.NewReader(pcm[:len(samples)*2]), binary.LittleEndian, samples)
+	return &voiceNote{
+		Data:     data,
+		Seconds:  uint32((len(samples) + 7999) / 8000),
+		Waveform: waveform(samples),
+	}, nil
+}
+
+// Builds the 64 bar waveform shown for voice notes, with values from 0 to 100
+func waveform(samples []int16) []byte {
+	levels := make([]float64, waveformSamples)
+	var max float64
+	for i := range levels {
+		chunk := samples[i*len(samples)/waveformSamples : (i+1)*len(samples)/waveformSamples]
+		if len(chunk) == 0 {
+			continue
+		}
+		var sum float64
+		for _, s := range chunk {
+			if s < 0 {
+				sum -= float64(s)
+			} else {
+				sum += float64(s)
+			}
+		}
+		levels[i] = sum / float64(len(chunk))
+		if levels[i] > max {
+			max = levels[i]
+		}
+	}
+	result := make([]byte, waveformSamples)
+	if max == 0 {
+		return result
+	}
+	for i, level := range levels {
+		result[i] = byte(level / max * 100)
+	}
+	return result
+}
+
+func (f *ffmpegConverter) Sticker(data []byte) ([]byte, error) {
+	scale := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,format=rgba,pad=%d:%d:(ow-iw)/2:(oh-ih)/2:color=0x00000000", stickerSize, stickerSize, stickerSize, stickerSize)
+	sticker, err := f.run(data, f.ffmpeg, "-v", "error", "-i", "{input}", "-vf", scale, "-frames:v", "1", "-c:v", "libwebp", "-lossless", "0", "-q:v", "80", "-f", "webp", "pipe:1")
+	if err != nil {
+		return nil, fmt.Errorf("could not convert sticker: %v", err)
+	}
+	return sticker, nil
+}
+
+func (f *ffmpegConverter) Preview(data []byte) (*mediaPreview, error) {
+	info, err := f.run(data, f.ffprobe, "-v", "error", "-select_streams", "v:0", "-show_entries", "stream=width,height:format=duration", "-of", "default=noprint_wrappers=1", "{input}")
+	if err != nil {
+		return nil, fmt.Errorf("could not probe media: %v", err)
+	}
+	preview := &mediaPreview{}
+	for _, line := range strings.Split(string(info), "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "width":
+			n, _ := strconv.ParseUint(value, 10, 32)
+			preview.Width = uint32(n)
+		case "height":
+			n, _ := strconv.ParseUint(value, 10, 32)
+			preview.Height = uint32(n)
+		case "duration":
+			d, _ := strconv.ParseFloat(value, 64)
+			preview.Seconds = uint32(d + 0.5)
+		}
+	}
+
+	scale := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", thumbnailSize, thumbnailSize)
+	preview.JpegThumbnail, err = f.run(data, f.ffmpeg, "-v", "error", "-i", "{input}", "-vf", scale, "-frames:v", "1", "-c:v", "mjpeg", "-f", "image2", "pipe:1")
+	if err != nil {
+		return nil, fmt.Errorf("could not generate thumbnail: %v", err)
+	}
+	return preview, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// Converter returning canned results, recording what it was asked to convert
+type stubConverter struct {
+	voice   *voiceNote
+	sticker []byte
+	preview *mediaPreview
+	err     error
+	calls   []string
+}
+
+func (c *stubConverter) Voice(data []byte, mimetype string) (*voiceNote, error) {
+	c.calls = append(c.calls, "voice "+mimetype)
+	return c.voice, c.err
+}
+
+func (c *stubConverter) Sticker(data []byte) ([]byte, error) {
+	c.calls = append(c.calls, "sticker")
+	return c.sticker, c.err
+}
+
+func (c *stubConverter) Preview(data []byte) (*mediaPreview, error) {
+	c.calls = append(c.calls, "preview")
+	return c.preview, c.err
+}
+
+// Swaps the global converter for the duration of a test
+func useConverter(t *testing.T, c mediaConverter) {
+	previous := converter
+	converter = c
+	t.Cleanup(func() { converter = previous })
+}
+
+// First ogg page carrying the identification header of the given codec
+func oggPage(header string) []byte {
+	page := []byte("OggS")
+	page = append(page, make([]byte, 22)...)
+	page = append(page, 1, byte(len(header)))
+	return append(page, header...)
+}
+
+var (
+	oggOpus   = oggPage("OpusHead\x01\x01\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+	oggVorbis = oggPage("\x01vorbis\x00\x00\x00\x00\x01\x44\xac\x00\x00")
+)
+
+func TestIsOggOpus(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"opus", oggOpus, true},
+		{"vorbis", oggVorbis, false},
+		{"mp3", []byte("ID3\x04\x00\x00\x00\x00\x00\x00"), false},
+		{"empty", nil, false},
+		{"truncated page", oggOpus[:30], false},
+		{"segment table past the end", append([]byte("OggS"), make([]byte, 22)...), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isOggOpus(tt.data); got != tt.want {
+				t.Errorf("isOggOpus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVoiceFor(t *testing.T) {
+	converted := &voiceNote{Data: []byte("converted"), Seconds: 3, Waveform: []byte{1, 2}}
+	tests := []struct {
+		name      string
+		converter mediaConverter
+		media     *mediaFile
+		want      []byte
+		status    int
+	}{
+		{"ogg/opus without converter", noConverter{}, &mediaFile{Data: oggOpus, Mimetype: "audio/ogg"}, oggOpus, 0},
+		{"ogg/vorbis without converter", noConverter{}, &mediaFile{Data: oggVorbis, Mimetype: "audio/ogg"}, nil, http.StatusBadRequest},
+		{"mp3 without converter", noConverter{}, &mediaFile{Data: []byte("ID3"), Mimetype: "audio/mpeg"}, nil, http.StatusBadRequest},
+		{"mp3 converted", &stubConverter{voice: converted}, &mediaFile{Data: []byte("ID3"), Mimetype: "audio/mpeg"}, converted.Data, 0},
+		{"conversion failure", &stubConverter{err: errors.New("bad audio")}, &mediaFile{Data: []byte("ID3"), Mimetype: "audio/mpeg"}, nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConverter(t, tt.converter)
+			voice, status, err := voiceFor(tt.media)
+			if status != tt.status {
+				t.Fatalf("voiceFor() status = %d, want %d (err %v)", status, tt.status, err)
+			}
+			if tt.status != 0 {
+				if err == nil {
+					t.Fatal("voiceFor() returned no error")
+				}
+				return
+			}
+			if !bytes.Equal(voice.Data, tt.want) {
+				t.Errorf("voiceFor() data = %q, want %q", voice.Data, tt.want)
+			}
+		})
+	}
+}
+
+func TestStickerFor(t *testing.T) {
+	tests := []struct {
+		name      string
+		converter *stubConverter
+		media     *mediaFile
+		want      []byte
+		status    int
+		converted bool
+	}{
+		{"webp is sent as is", &stubConverter{err: errNoConverter}, &mediaFile{Data: []byte("RIFF"), Mimetype: "image/webp"}, []byte("RIFF"), 0, false},
+		{"png converted", &stubConverter{sticker: []byte("webp")}, &mediaFile{Data: []byte("png"), Mimetype: "image/png"}, []byte("webp"), 0, true},
+		{"png without converter", &stubConverter{err: errNoConverter}, &mediaFile{Data: []byte("png"), Mimetype: "image/png"}, nil, http.StatusBadRequest, true},
+		{"conversion failure", &stubConverter{err: errors.New("bad image")}, &mediaFile{Data: []byte("png"), Mimetype: "image/png"}, nil, http.StatusBadRequest, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConverter(t, tt.converter)
+			data, status, err := stickerFor(tt.media)
+			if status != tt.status {
+				t.Fatalf("stickerFor() status = %d, want %d (err %v)", status, tt.status, err)
+			}
+			if !bytes.Equal(data, tt.want) {
+				t.Errorf("stickerFor() = %q, want %q", data, tt.want)
+			}
+			if converted := len(tt.converter.calls) > 0; converted != tt.converted {
+				t.Errorf("converter called = %v, want %v", converted, tt.converted)
+			}
+		})
+	}
+}
+
+func TestPreviewFor(t *testing.T) {
+	preview := &mediaPreview{JpegThumbnail: []byte("jpeg"), Width: 640, Height: 480, Seconds: 5}
+	tests := []struct {
+		name      string
+		converter mediaConverter
+		want      *mediaPreview
+	}{
+		{"generated", &stubConverter{preview: preview}, preview},
+		{"without converter", noConverter{}, nil},
+		{"conversion failure", &stubConverter{err: errors.New("bad video")}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConverter(t, tt.converter)
+			if got := previewFor([]byte("media"), "video"); got != tt.want {
+				t.Errorf("previewFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaveform(t *testing.T) {
+	if got := waveform(nil); !bytes.Equal(got, make([]byte, waveformSamples)) {
+		t.Errorf("waveform of silence = %v, want all zeros", got)
+	}
+
+	samples := make([]int16, waveformSamples*10)
+	for i := range samples {
+		if i >= len(samples)/2 {
+			samples[i] = -1000
+		}
+	}
+	got := waveform(samples)
+	if len(got) != waveformSamples {
+		t.Fatalf("waveform length = %d, want %d", len(got), waveformSamples)
+	}
+	if got[0] != 0 || got[waveformSamples-1] != 100 {
+		t.Errorf("waveform = %v, want 0 for the silent half and 100 for the loud half", got)
+	}
+}
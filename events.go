@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	eventBufferSize      = 500
+	eventSubscriberQueue = 64
+	eventKeepAlive       = 30 * time.Second
+)
+
+// Event as delivered to /events/stream clients
+type streamEvent struct {
+	Id   uint64
+	Type string
+	Data []byte
+}
+
+// Keeps the latest events of a user so stream clients can resume after reconnecting
+type eventHub struct {
+	mu          sync.Mutex
+	lastId      uint64
+	buffer      []streamEvent
+	next        int
+	subscribers map[chan streamEvent]bool
+}
+
+var (
+	eventHubs   = make(map[int]*eventHub)
+	eventHubsMu sync.Mutex
+)
+
+var upgrader = websocket.Upgrader{
+	// Clients authenticate with their token, so any origin is accepted
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// Returns the event hub of a user, creating it if needed
+func getEventHub(userID int) *eventHub {
+	eventHubsMu.Lock()
+	defer eventHubsMu.Unlock()
+	hub, ok := eventHubs[userID]
+	if !ok {
+		// Ids are seeded from the clock so they keep growing across restarts
+		hub = &eventHub{
+			lastId:      uint64(time.Now().UnixNano() / int64(time.Millisecond) * 1000),
+			subscribers: make(map[chan streamEvent]bool),
+		}
+		eventHubs[userID] = hub
+	}
+	return hub
+}
+
+// Stores an event in the ring buffer and sends it to connected stream clients
+func (h *eventHub) publish(eventType string, data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastId++
+	evt := streamEvent{Id: h.lastId, Type: eventType, Data: data}
+	if len(h.buffer) < eventBufferSize {
+		h.buffer = append(h.buffer, evt)
+	} else {
+		h.buffer[h.next] = evt
+		h.next = (h.next + 1) % eventBufferSize
+	}
+	for ch := range h.subscribers {
+		select {
+		case ch <- evt:
+		default:
+			// Client is not keeping up, drop it so it reconnects and resumes from the buffer
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Registers a stream client, returning buffered events newer than lastId
+func (h *eventHub) subscribe(lastId uint64) (chan streamEvent, []streamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var missed []streamEvent
+	if lastId != 0 {
+		for i := 0; i < len(h.buffer); i++ {
+			evt := h.buffer[(h.next+i)%len(h.buffer)]
+			if evt.Id > lastId {
+				missed = append(missed, evt)
+			}
+		}
+	}
+	ch := make(chan streamEvent, eventSubscriberQueue)
+	h.subscribers[ch] = true
+	return ch, missed
+}
+
+func (h *eventHub) unsubscribe(ch chan streamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[ch] {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Streams events as Server-Sent Events or over a WebSocket
+func (s *server) StreamEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		// Resume point from the SSE header or the uri parameter
+		lastEventId := r.Header.Get("Last-Event-ID")
+		if lastEventId == "" {
+			lastEventId = r.URL.Query().Get("last_event_id")
+		}
+		var lastId uint64
+		if lastEventId != "" {
+			var err error
+			lastId, err = strconv.ParseUint(lastEventId, 10, 64)
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("invalid last event id"))
+				return
+			}
+		}
+
+		if websocket.IsWebSocketUpgrade(r) {
+			s.streamWebsocket(w, r, userid, lastId)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("streaming not supported"))
+			return
+		}
+
+		hub := getEventHub(userid)
+		ch, missed := hub.subscribe(lastId)
+		defer hub.unsubscribe(ch)
+
+		log.Info().Str("userid", txtid).Uint64("last_event_id", lastId).Msg("Event stream opened")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "retry: 3000\n\n")
+		for _, evt := range missed {
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", evt.Id, evt.Type, evt.Data)
+		}
+		flusher.Flush()
+
+		keepalive := time.NewTicker(eventKeepAlive)
+		defer keepalive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				log.Info().Str("userid", txtid).Msg("Event stream closed")
+				return
+			case <-keepalive.C:
+				fmt.Fprintf(w, ": keepalive\n\n")
+			case evt, ok := <-ch:
+				if !ok {
+					log.Warn().Str("userid", txtid).Msg("Event stream dropped, client too slow")
+					return
+				}
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", evt.Id, evt.Type, evt.Data)
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// Message written to WebSocket stream clients
+type websocketEvent struct {
+	Id    uint64          `json:"id"`
+	Type  string          `json:"type"`
+	Event json.RawMessage `json:"event"`
+}
+
+func (s *server) streamWebsocket(w http.ResponseWriter, r *http.Request, userid int, lastId uint64) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Warn().Err(err).Msg("Could not upgrade event stream to websocket")
+		return
+	}
+	defer conn.Close()
+
+	hub := getEventHub(userid)
+	ch, missed := hub.subscribe(lastId)
+	defer hub.unsubscribe(ch)
+	log.Info().Int("userid", userid).Uint64("last_event_id", lastId).Msg("Websocket event stream opened")
+
+	// Reads are only needed to process control frames and notice the client going away
+	closed := make(chan bool)
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, evt := range missed {
+		if err = conn.WriteJSON(websocketEvent{Id: evt.Id, Type: evt.Type, Event: evt.Data}); err != nil {
+			return
+		}
+	}
+
+	keepalive := time.NewTicker(eventKeepAlive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-closed:
+			log.Info().Int("userid", userid).Msg("Websocket event stream closed")
+			return
+		case <-keepalive.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+		case evt, ok := <-ch:
+			if !ok {
+				log.Warn().Int("userid", userid).Msg("Websocket event stream dropped, client too slow")
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"), time.Now().Add(time.Second))
+				return
+			}
+			err = conn.WriteJSON(websocketEvent{Id: evt.Id, Type: evt.Type, Event: evt.Data})
+		}
+		if err != nil {
+			log.Warn().Err(err).Int("userid", userid).Msg("Websocket event stream write failed")
+			return
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestHub(lastId uint64) *eventHub {
+	return &eventHub{lastId: lastId, subscribers: make(map[chan streamEvent]bool)}
+}
+
+func eventIds(events []streamEvent) []uint64 {
+	ids := make([]uint64, len(events))
+	for i, evt := range events {
+		ids[i] = evt.Id
+	}
+	return ids
+}
+
+func TestEventHubResume(t *testing.T) {
+	hub := newTestHub(100)
+	for i := 0; i < 5; i++ {
+		hub.publish("Message", []byte(`{}`))
+	}
+
+	tests := []struct {
+		name   string
+		lastId uint64
+		want   []uint64
+	}{
+		{"new client", 0, []uint64{}},
+		{"resumed", 102, []uint64{103, 104, 105}},
+		{"up to date", 105, []uint64{}},
+		{"older than the buffer", 1, []uint64{101, 102, 103, 104, 105}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, missed := hub.subscribe(tt.lastId)
+			defer hub.unsubscribe(ch)
+			if got := eventIds(missed); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("missed events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventHubRingBuffer(t *testing.T) {
+	hub := newTestHub(0)
+	for i := 0; i < eventBufferSize+10; i++ {
+		hub.publish("Message", nil)
+	}
+	_, missed := hub.subscribe(1)
+	if len(missed) != eventBufferSize {
+		t.Fatalf("buffered %d events, want %d", len(missed), eventBufferSize)
+	}
+	for i, evt := range missed {
+		if want := uint64(11 + i); evt.Id != want {
+			t.Fatalf("event %d has id %d, want %d in order", i, evt.Id, want)
+		}
+	}
+}
+
+func TestEventHubSubscribers(t *testing.T) {
+	hub := newTestHub(0)
+	live, _ := hub.subscribe(0)
+	slow, _ := hub.subscribe(0)
+
+	hub.publish("Message", []byte(`{"type":"Message"}`))
+	if evt := <-live; evt.Id != 1 || evt.Type != "Message" || string(evt.Data) != `{"type":"Message"}` {
+		t.Errorf("received %+v", evt)
+	}
+
+	// The slow client never reads, once its queue is full it is dropped
+	for i := 0; i < eventSubscriberQueue; i++ {
+		hub.publish("Message", nil)
+		<-live
+	}
+	for range slow {
+	}
+	if hub.subscribers[slow] {
+		t.Error("slow subscriber was not dropped")
+	}
+	if !hub.subscribers[live] {
+		t.Error("live subscriber was dropped")
+	}
+
+	hub.unsubscribe(live)
+	if _, open := <-live; open {
+		t.Error("unsubscribed channel is still open")
+	}
+	hub.unsubscribe(live)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Disappearing message timers accepted by /group/settings
+var ephemeralTimers = map[string]time.Duration{
+	"off": whatsmeow.DisappearingTimerOff,
+	"24h": whatsmeow.DisappearingTimer24Hours,
+	"7d":  whatsmeow.DisappearingTimer7Days,
+	"90d": whatsmeow.DisappearingTimer90Days,
+}
+
+var participantActions = []string{"add", "remove", "promote", "demote"}
+
+// Result of a participant change. Error is 0 on success or the code returned by WhatsApp, such as
+// 403 when the user only accepts invites (the invite code is then in AddRequest), 408 when they
+// recently left the group or 409 when they are already a participant.
+type participantResult struct {
+	types.GroupParticipant
+	Action string
+}
+
+// Parses the phone numbers or jids of participants
+func parseParticipants(phones []string) ([]types.JID, error) {
+	participants := make([]types.JID, 0, len(phones))
+	for _, phone := range phones {
+		jid, ok := parseJID(phone)
+		if !ok {
+			return nil, fmt.Errorf("could not parse participant %s", phone)
+		}
+		participants = append(participants, jid)
+	}
+	return participants, nil
+}
+
+// Gets the result for every participant from the response to a participant change
+func parseParticipantResults(resp *waBinary.Node) []participantResult {
+	results := []participantResult{}
+	for _, action := range resp.GetChildren() {
+		for _, child := range action.GetChildrenByTag("participant") {
+			ag := child.AttrGetter()
+			result := participantResult{Action: action.Tag}
+			result.JID = ag.JID("jid")
+			result.Error = ag.OptionalInt("error")
+			if addRequest, ok := child.GetOptionalChildByTag("add_request"); ok {
+				addAG := addRequest.AttrGetter()
+				result.AddRequest = &types.GroupParticipantAddRequest{
+					Code:       addAG.String("code"),
+					Expiration: addAG.UnixTime("expiration"),
+				}
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// Group change as sent with the GroupUpdate event, only the changed fields are set
+type groupUpdate struct {
+	Group      string      `json:"group"`
+	Actor      string      `json:"actor,omitempty"`
+	Timestamp  time.Time   `json:"timestamp"`
+	Join       []string    `json:"join,omitempty"`
+	JoinReason string      `json:"joinReason,omitempty"`
+	Leave      []string    `json:"leave,omitempty"`
+	Promote    []string    `json:"promote,omitempty"`
+	Demote     []string    `json:"demote,omitempty"`
+	Name       *string     `json:"name,omitempty"`
+	Topic      *string     `json:"topic,omitempty"`
+	Announce   *bool       `json:"announce,omitempty"`
+	Locked     *bool       `json:"locked,omitempty"`
+	Ephemeral  *uint32     `json:"ephemeral,omitempty"`
+	Photo      *groupPhoto `json:"photo,omitempty"`
+	InviteLink *string     `json:"inviteLink,omitempty"`
+	Deleted    bool        `json:"deleted,omitempty"`
+}
+
+type groupPhoto struct {
+	Id      string `json:"id,omitempty"`
+	Removed bool   `json:"removed,omitempty"`
+}
+
+func jidStrings(jids []types.JID) []string {
+	if len(jids) == 0 {
+		return nil
+	}
+	list := make([]string, len(jids))
+	for i, jid := range jids {
+		list[i] = jid.ToNonAD().String()
+	}
+	return list
+}
+
+func newGroupUpdate(evt *events.GroupInfo) *groupUpdate {
+	update := &groupUpdate{
+		Group:      evt.JID.String(),
+		Timestamp:  evt.Timestamp,
+		Join:       jidStrings(evt.Join),
+		JoinReason: evt.JoinReason,
+		Leave:      jidStrings(evt.Leave),
+		Promote:    jidStrings(evt.Promote),
+		Demote:     jidStrings(evt.Demote),
+		InviteLink: evt.NewInviteLink,
+	}
+	if evt.Sender != nil {
+		update.Actor = evt.Sender.ToNonAD().String()
+	}
+	if evt.Name != nil {
+		update.Name = &evt.Name.Name
+	}
+	if evt.Topic != nil {
+		update.Topic = &evt.Topic.Topic
+	}
+	if evt.Announce != nil {
+		update.Announce = &evt.Announce.IsAnnounce
+	}
+	if evt.Locked != nil {
+		update.Locked = &evt.Locked.IsLocked
+	}
+	if evt.Ephemeral != nil {
+		update.Ephemeral = &evt.Ephemeral.DisappearingTimer
+	}
+	if evt.Delete != nil {
+		update.Deleted = evt.Delete.Deleted
+	}
+	return update
+}
+
+// Group photo changes come as picture events for the group jid
+func newGroupPhotoUpdate(evt *events.Picture) *groupUpdate {
+	return &groupUpdate{
+		Group:     evt.JID.String(),
+		Actor:     evt.Author.ToNonAD().String(),
+		Timestamp: evt.Timestamp,
+		Photo:     &groupPhoto{Id: evt.PictureID, Removed: evt.Remove},
+	}
+}
+
+var joinRequestActions = []string{"approve", "reject"}
+
+// Pending request to join a group with admin approval
+type joinRequest struct {
+	JID         types.JID
+	RequestedAt time.Time
+}
+
+// New join requests as sent with the GroupJoinRequest event
+type joinRequestEvent struct {
+	Group     string    `json:"group"`
+	Method    string    `json:"method,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Requests  []string  `json:"requests"`
+}
+
+// Sends a w:g2 iq, whatsmeow has no support for join requests so they are sent directly
+func sendGroupIQ(client *whatsmeow.Client, iqType whatsmeow.DangerousInfoQueryType, group types.JID, content waBinary.Node) (*waBinary.Node, error) {
+	return client.DangerousInternals().SendIQ(whatsmeow.DangerousInfoQuery{
+		Context:   context.Background(),
+		Namespace: "w:g2",
+		Type:      iqType,
+		To:        group,
+		Content:   []waBinary.Node{content},
+	})
+}
+
+// Gets the pending join requests of a group
+func getJoinRequests(client *whatsmeow.Client, group types.JID) ([]joinRequest, error) {
+	resp, err := sendGroupIQ(client, "get", group, waBinary.Node{Tag: "membership_approval_requests"})
+	if err != nil {
+		return nil, err
+	}
+	requests := []joinRequest{}
+	list, ok := resp.GetOptionalChildByTag("membership_approval_requests")
+	if !ok {
+		return requests, nil
+	}
+	for _, child := range list.GetChildrenByTag("membership_approval_request") {
+		ag := child.AttrGetter()
+		requests = append(requests, joinRequest{JID: ag.JID("jid"), RequestedAt: ag.UnixTime("request_time")})
+		if !ag.OK() {
+			return nil, fmt.Errorf("failed to parse join request: %w", ag.Error())
+		}
+	}
+	return requests, nil
+}
+
+// Approves or rejects join requests, returning the result for every participant
+func updateJoinRequests(client *whatsmeow.Client, group types.JID, action string, participants []types.JID) ([]participantResult, error) {
+	nodes := make([]waBinary.Node, len(participants))
+	for i, participant := range participants {
+		nodes[i] = waBinary.Node{Tag: "participant", Attrs: waBinary.Attrs{"jid": participant}}
+	}
+	resp, err := sendGroupIQ(client, "set", group, waBinary.Node{
+		Tag:     "membership_requests_action",
+		Content: []waBinary.Node{{Tag: action, Content: nodes}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	actions, ok := resp.GetOptionalChildByTag("membership_requests_action")
+	if !ok {
+		return []participantResult{}, nil
+	}
+	return parseParticipantResults(&actions), nil
+}
+
+// Gets new join requests from the changes of a group notification, which whatsmeow leaves unparsed
+func newJoinRequestEvent(evt *events.GroupInfo) *joinRequestEvent {
+	var request *joinRequestEvent
+	for _, change := range evt.UnknownChanges {
+		if change.Tag != "created_membership_requests" {
+			continue
+		}
+		if request == nil {
+			request = &joinRequestEvent{Group: evt.JID.String(), Timestamp: evt.Timestamp, Requests: []string{}}
+		}
+		request.Method = change.AttrGetter().OptionalString("request_method")
+		for _, user := range change.GetChildrenByTag("requested_user") {
+			jid := user.AttrGetter().OptionalJIDOrEmpty("jid")
+			if !jid.IsEmpty() {
+				request.Requests = append(request.Requests, jid.ToNonAD().String())
+			}
+		}
+	}
+	return request
+}
+
+// Community with its linked groups, the announcement group is the default subgroup
+type communityInfo struct {
+	types.GroupInfo
+	AnnouncementGroup *types.GroupLinkTarget
+	SubGroups         []types.GroupLinkTarget
+}
+
+// Gets the linked groups of a community
+func getCommunity(client *whatsmeow.Client, info *types.GroupInfo) (*communityInfo, error) {
+	subGroups, err := client.GetSubGroups(info.JID)
+	if err != nil {
+		return nil, err
+	}
+	return newCommunityInfo(info, subGroups), nil
+}
+
+// Splits the linked groups of a community into its announcement group and the other subgroups
+func newCommunityInfo(info *types.GroupInfo, subGroups []*types.GroupLinkTarget) *communityInfo {
+	community := &communityInfo{GroupInfo: *info, SubGroups: []types.GroupLinkTarget{}}
+	for _, subGroup := range subGroups {
+		if subGroup.IsDefaultSubGroup {
+			community.AnnouncementGroup = subGroup
+		} else {
+			community.SubGroups = append(community.SubGroups, *subGroup)
+		}
+	}
+	return community
+}
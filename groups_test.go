@@ -0,0 +1,219 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	waBinary "go.mau.fi/whatsmeow/binary"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func TestParseParticipantResults(t *testing.T) {
+	added := types.NewJID("5491155553935", types.DefaultUserServer)
+	invited := types.NewJID("5491155551111", types.DefaultUserServer)
+	removed := types.NewJID("5491155552222", types.DefaultUserServer)
+
+	tests := []struct {
+		name string
+		resp *waBinary.Node
+		want []participantResult
+	}{
+		{
+			name: "add and remove",
+			resp: &waBinary.Node{Tag: "iq", Content: []waBinary.Node{
+				{Tag: "add", Content: []waBinary.Node{
+					{Tag: "participant", Attrs: waBinary.Attrs{"jid": added}},
+					{Tag: "participant", Attrs: waBinary.Attrs{"jid": invited, "error": "403"}, Content: []waBinary.Node{
+						{Tag: "add_request", Attrs: waBinary.Attrs{"code": "AbCdEf", "expiration": "1687600000"}},
+					}},
+				}},
+				{Tag: "remove", Content: []waBinary.Node{
+					{Tag: "participant", Attrs: waBinary.Attrs{"jid": removed, "error": "404"}},
+				}},
+			}},
+			want: []participantResult{
+				{Action: "add", GroupParticipant: types.GroupParticipant{JID: added}},
+				{Action: "add", GroupParticipant: types.GroupParticipant{JID: invited, Error: 403, AddRequest: &types.GroupParticipantAddRequest{
+					Code:       "AbCdEf",
+					Expiration: time.Unix(1687600000, 0),
+				}}},
+				{Action: "remove", GroupParticipant: types.GroupParticipant{JID: removed, Error: 404}},
+			},
+		},
+		{
+			name: "other children are ignored",
+			resp: &waBinary.Node{Tag: "iq", Content: []waBinary.Node{
+				{Tag: "promote", Content: []waBinary.Node{{Tag: "participant", Attrs: waBinary.Attrs{"jid": added}}, {Tag: "unknown"}}},
+			}},
+			want: []participantResult{{Action: "promote", GroupParticipant: types.GroupParticipant{JID: added}}},
+		},
+		{
+			name: "empty response",
+			resp: &waBinary.Node{Tag: "iq"},
+			want: []participantResult{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseParticipantResults(tt.resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseParticipantResults() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseParticipants(t *testing.T) {
+	got, err := parseParticipants([]string{"5491155553935", "5491155551111@s.whatsapp.net"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []types.JID{types.NewJID("5491155553935", types.DefaultUserServer), types.NewJID("5491155551111", types.DefaultUserServer)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseParticipants() = %v, want %v", got, want)
+	}
+	if _, err = parseParticipants([]string{"5491155553935", "not a phone"}); err == nil {
+		t.Error("parseParticipants() accepted an invalid phone")
+	}
+}
+
+func TestNewGroupUpdate(t *testing.T) {
+	group := types.NewJID("120363025246125486", types.GroupServer)
+	admin := types.NewADJID("5491155554444", 0, 2)
+	member := types.NewJID("5491155553935", types.DefaultUserServer)
+	at := time.Date(2023, 6, 21, 12, 0, 0, 0, time.UTC)
+	name, announce, timer := "Team", true, uint32(86400)
+
+	tests := []struct {
+		name string
+		evt  *events.GroupInfo
+		want *groupUpdate
+	}{
+		{
+			name: "participants",
+			evt:  &events.GroupInfo{JID: group, Sender: &admin, Timestamp: at, Join: []types.JID{member}, JoinReason: "invite", Promote: []types.JID{member}},
+			want: &groupUpdate{Group: group.String(), Actor: "5491155554444@s.whatsapp.net", Timestamp: at, Join: []string{member.String()}, JoinReason: "invite", Promote: []string{member.String()}},
+		},
+		{
+			name: "settings",
+			evt: &events.GroupInfo{
+				JID:       group,
+				Timestamp: at,
+				Name:      &types.GroupName{Name: name},
+				Announce:  &types.GroupAnnounce{IsAnnounce: announce},
+				Ephemeral: &types.GroupEphemeral{IsEphemeral: true, DisappearingTimer: timer},
+			},
+			want: &groupUpdate{Group: group.String(), Timestamp: at, Name: &name, Announce: &announce, Ephemeral: &timer},
+		},
+		{
+			name: "deleted",
+			evt:  &events.GroupInfo{JID: group, Timestamp: at, Delete: &types.GroupDelete{Deleted: true}},
+			want: &groupUpdate{Group: group.String(), Timestamp: at, Deleted: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newGroupUpdate(tt.evt); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newGroupUpdate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	photo := newGroupPhotoUpdate(&events.Picture{JID: group, Author: admin, Timestamp: at, PictureID: "1687340000"})
+	want := &groupUpdate{Group: group.String(), Actor: "5491155554444@s.whatsapp.net", Timestamp: at, Photo: &groupPhoto{Id: "1687340000"}}
+	if !reflect.DeepEqual(photo, want) {
+		t.Errorf("newGroupPhotoUpdate() = %+v, want %+v", photo, want)
+	}
+}
+
+func TestNewJoinRequestEvent(t *testing.T) {
+	group := types.NewJID("120363025246125486", types.GroupServer)
+	requester := types.NewADJID("5491155553935", 0, 1)
+	other := types.NewJID("5491155551111", types.DefaultUserServer)
+	at := time.Date(2023, 6, 21, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		changes []*waBinary.Node
+		want    *joinRequestEvent
+	}{
+		{
+			name: "requests by invite link",
+			changes: []*waBinary.Node{{
+				Tag:   "created_membership_requests",
+				Attrs: waBinary.Attrs{"request_method": "invite_link"},
+				Content: []waBinary.Node{
+					{Tag: "requested_user", Attrs: waBinary.Attrs{"jid": requester}},
+					{Tag: "requested_user", Attrs: waBinary.Attrs{"jid": other}},
+					{Tag: "requested_user"},
+				},
+			}},
+			want: &joinRequestEvent{Group: group.String(), Method: "invite_link", Timestamp: at, Requests: []string{"5491155553935@s.whatsapp.net", other.String()}},
+		},
+		{
+			name: "other changes are skipped",
+			changes: []*waBinary.Node{
+				{Tag: "membership_approval_mode"},
+				{Tag: "created_membership_requests", Content: []waBinary.Node{{Tag: "requested_user", Attrs: waBinary.Attrs{"jid": other}}}},
+			},
+			want: &joinRequestEvent{Group: group.String(), Timestamp: at, Requests: []string{other.String()}},
+		},
+		{
+			name:    "no requests",
+			changes: []*waBinary.Node{{Tag: "membership_approval_mode"}},
+		},
+		{
+			name: "no unknown changes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evt := &events.GroupInfo{JID: group, Timestamp: at, UnknownChanges: tt.changes}
+			if got := newJoinRequestEvent(evt); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newJoinRequestEvent() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCommunityInfo(t *testing.T) {
+	info := &types.GroupInfo{
+		JID:         types.NewJID("120363025246125486", types.GroupServer),
+		GroupName:   types.GroupName{Name: "Neighbours"},
+		GroupParent: types.GroupParent{IsParent: true},
+	}
+	announcement := &types.GroupLinkTarget{
+		JID:               types.NewJID("120363025246125487", types.GroupServer),
+		GroupName:         types.GroupName{Name: "Neighbours"},
+		GroupIsDefaultSub: types.GroupIsDefaultSub{IsDefaultSubGroup: true},
+	}
+	garden := &types.GroupLinkTarget{JID: types.NewJID("120363025246125488", types.GroupServer), GroupName: types.GroupName{Name: "Garden"}}
+	parking := &types.GroupLinkTarget{JID: types.NewJID("120363025246125489", types.GroupServer), GroupName: types.GroupName{Name: "Parking"}}
+
+	tests := []struct {
+		name         string
+		subGroups    []*types.GroupLinkTarget
+		announcement *types.GroupLinkTarget
+		want         []types.GroupLinkTarget
+	}{
+		{"announcement group and subgroups", []*types.GroupLinkTarget{garden, announcement, parking}, announcement, []types.GroupLinkTarget{*garden, *parking}},
+		{"only the announcement group", []*types.GroupLinkTarget{announcement}, announcement, []types.GroupLinkTarget{}},
+		{"no announcement group", []*types.GroupLinkTarget{parking}, nil, []types.GroupLinkTarget{*parking}},
+		{"no linked groups", nil, nil, []types.GroupLinkTarget{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			community := newCommunityInfo(info, tt.subGroups)
+			if community.JID != info.JID || community.Name != "Neighbours" || !community.IsParent {
+				t.Errorf("group info = %+v, want the community info", community.GroupInfo)
+			}
+			if community.AnnouncementGroup != tt.announcement {
+				t.Errorf("AnnouncementGroup = %+v, want %+v", community.AnnouncementGroup, tt.announcement)
+			}
+			if !reflect.DeepEqual(community.SubGroups, tt.want) {
+				t.Errorf("SubGroups = %+v, want %+v", community.SubGroups, tt.want)
+			}
+		})
+	}
+}
@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/patrickmn/go-cache"
 	"github.com/vincent-petithory/dataurl"
 	"go.mau.fi/whatsmeow"
@@ -33,9 +38,36 @@ var messageTypes = []string{
 	"Presence",
 	"HistorySync",
 	"ChatPresence",
+	"MessageEdit",
+	"MessageRevoke",
+	"PollVote",
+	"InteractiveReply",
+	"Call",
+	"GroupUpdate",
+	"JoinedGroup",
+	"GroupJoinRequest",
 	"All",
 }
 
+// Gets the valid event types to subscribe to, all of them if none is given
+func parseSubscriptions(events []string) []string {
+	var subscribedEvents []string
+	if len(events) < 1 {
+		subscribedEvents = append(subscribedEvents, "All")
+		return subscribedEvents
+	}
+	for _, arg := range events {
+		if !Find(messageTypes, arg) {
+			log.Warn().Str("Type", arg).Msg("Message type discarded")
+			continue
+		}
+		if !Find(subscribedEvents, arg) {
+			subscribedEvents = append(subscribedEvents, arg)
+		}
+	}
+	return subscribedEvents
+}
+
 func (s *server) authalice(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -45,6 +77,12 @@ func (s *server) authalice(next http.Handler) http.Handler {
 		webhook := ""
 		jid := ""
 		events := ""
+		webhookFormat := ""
+		webhookSecret := ""
+		webhookMedia := ""
+		webhookSchema := ""
+		webhookRaw := ""
+		mediaDownload := ""
 
 		// Get token from headers or uri parameters
 		token := r.Header.Get("token")
@@ -57,7 +95,7 @@ func (s *server) authalice(next http.Handler) http.Handler {
 			log.Info().Msg("Looking for user information in DB")
 			// Checks DB from matching user and store user values in context
 			rows, err := s.db.Query(
-				"SELECT id,webhook,jid,events FROM users WHERE token=? LIMIT 1",
+				"SELECT id,webhook,jid,events,webhook_format,webhook_secret,webhook_media,webhook_schema,webhook_raw,media_download FROM users WHERE token=? LIMIT 1",
 				token,
 			)
 			if err != nil {
@@ -66,18 +104,24 @@ func (s *server) authalice(next http.Handler) http.Handler {
 			}
 			defer rows.Close()
 			for rows.Next() {
-				err = rows.Scan(&txtid, &webhook, &jid, &events)
+				err = rows.Scan(&txtid, &webhook, &jid, &events, &webhookFormat, &webhookSecret, &webhookMedia, &webhookSchema, &webhookRaw, &mediaDownload)
 				if err != nil {
 					s.Respond(w, r, http.StatusInternalServerError, err)
 					return
 				}
 				userid, _ = strconv.Atoi(txtid)
 				v := Values{map[string]string{
-					"Id":      txtid,
-					"Jid":     jid,
-					"Webhook": webhook,
-					"Token":   token,
-					"Events":  events,
+					"Id":            txtid,
+					"Jid":           jid,
+					"Webhook":       webhook,
+					"Token":         token,
+					"Events":        events,
+					"WebhookFormat": webhookFormat,
+					"WebhookSecret": webhookSecret,
+					"WebhookMedia":  webhookMedia,
+					"WebhookSchema": webhookSchema,
+					"WebhookRaw":    webhookRaw,
+					"MediaDownload": mediaDownload,
 				}}
 
 				userinfocache.Set(token, v, cache.NoExpiration)
@@ -106,6 +150,12 @@ func (s *server) auth(handler http.HandlerFunc) http.HandlerFunc {
 		webhook := ""
 		jid := ""
 		events := ""
+		webhookFormat := ""
+		webhookSecret := ""
+		webhookMedia := ""
+		webhookSchema := ""
+		webhookRaw := ""
+		mediaDownload := ""
 
 		// Get token from headers or uri parameters
 		token := r.Header.Get("token")
@@ -118,7 +168,7 @@ func (s *server) auth(handler http.HandlerFunc) http.HandlerFunc {
 			log.Info().Msg("Looking for user information in DB")
 			// Checks DB from matching user and store user values in context
 			rows, err := s.db.Query(
-				"SELECT id,webhook,jid,events FROM users WHERE token=? LIMIT 1",
+				"SELECT id,webhook,jid,events,webhook_format,webhook_secret,webhook_media,webhook_schema,webhook_raw,media_download FROM users WHERE token=? LIMIT 1",
 				token,
 			)
 			if err != nil {
@@ -127,18 +177,24 @@ func (s *server) auth(handler http.HandlerFunc) http.HandlerFunc {
 			}
 			defer rows.Close()
 			for rows.Next() {
-				err = rows.Scan(&txtid, &webhook, &jid, &events)
+				err = rows.Scan(&txtid, &webhook, &jid, &events, &webhookFormat, &webhookSecret, &webhookMedia, &webhookSchema, &webhookRaw, &mediaDownload)
 				if err != nil {
 					s.Respond(w, r, http.StatusInternalServerError, err)
 					return
 				}
 				userid, _ = strconv.Atoi(txtid)
 				v := Values{map[string]string{
-					"Id":      txtid,
-					"Jid":     jid,
-					"Webhook": webhook,
-					"Token":   token,
-					"Events":  events,
+					"Id":            txtid,
+					"Jid":           jid,
+					"Webhook":       webhook,
+					"Token":         token,
+					"Events":        events,
+					"WebhookFormat": webhookFormat,
+					"WebhookSecret": webhookSecret,
+					"WebhookMedia":  webhookMedia,
+					"WebhookSchema": webhookSchema,
+					"WebhookRaw":    webhookRaw,
+					"MediaDownload": mediaDownload,
 				}}
 
 				userinfocache.Set(token, v, cache.NoExpiration)
@@ -188,22 +244,7 @@ func (s *server) Connect() http.HandlerFunc {
 			return
 		} else {
 
-			var subscribedEvents []string
-			if len(t.Subscribe) < 1 {
-				if !Find(subscribedEvents, "All") {
-					subscribedEvents = append(subscribedEvents, "All")
-				}
-			} else {
-				for _, arg := range t.Subscribe {
-					if !Find(messageTypes, arg) {
-						log.Warn().Str("Type", arg).Msg("Message type discarded")
-						continue
-					}
-					if !Find(subscribedEvents, arg) {
-						subscribedEvents = append(subscribedEvents, arg)
-					}
-				}
-			}
+			subscribedEvents := parseSubscriptions(t.Subscribe)
 			eventstring = strings.Join(subscribedEvents, ",")
 			_, err = s.db.Exec("UPDATE users SET events=? WHERE id=?", eventstring, userid)
 			if err != nil {
@@ -301,9 +342,14 @@ func (s *server) GetWebhook() http.HandlerFunc {
 
 		webhook := ""
 		events := ""
+		format := ""
+		secret := ""
+		media := ""
+		schema := ""
+		rawEvent := false
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		rows, err := s.db.Query("SELECT webhook,events FROM users WHERE id=? LIMIT 1", txtid)
+		rows, err := s.db.Query("SELECT webhook,events,webhook_format,webhook_secret,webhook_media,webhook_schema,webhook_raw FROM users WHERE id=? LIMIT 1", txtid)
 		if err != nil {
 			s.Respond(
 				w,
@@ -315,7 +361,7 @@ func (s *server) GetWebhook() http.HandlerFunc {
 		}
 		defer rows.Close()
 		for rows.Next() {
-			err = rows.Scan(&webhook, &events)
+			err = rows.Scan(&webhook, &events, &format, &secret, &media, &schema, &rawEvent)
 			if err != nil {
 				s.Respond(
 					w,
@@ -339,7 +385,7 @@ func (s *server) GetWebhook() http.HandlerFunc {
 
 		eventarray := strings.Split(events, ",")
 
-		response := map[string]interface{}{"webhook": webhook, "subscribe": eventarray}
+		response := map[string]interface{}{"webhook": webhook, "subscribe": eventarray, "format": format, "signed": secret != "", "media": media, "schema": schema, "rawEvent": rawEvent}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
@@ -354,6 +400,11 @@ func (s *server) GetWebhook() http.HandlerFunc {
 func (s *server) SetWebhook() http.HandlerFunc {
 	type webhookStruct struct {
 		WebhookURL string
+		Format     string
+		Secret     *string
+		Media      string
+		Schema     string
+		RawEvent   *bool
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -375,16 +426,75 @@ func (s *server) SetWebhook() http.HandlerFunc {
 		}
 		var webhook = t.WebhookURL
 
-		_, err = s.db.Exec("UPDATE users SET webhook=? WHERE id=?", webhook, userid)
+		// Format defaults to the legacy form encoded post
+		format := t.Format
+		if format == "" {
+			format = r.Context().Value("userinfo").(Values).Get("WebhookFormat")
+		}
+		if format == "" {
+			format = "form"
+		}
+		if format != "form" && format != "json" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("format should be form or json"))
+			return
+		}
+
+		// Secret is only changed when present in the payload, an empty string removes it
+		secret := r.Context().Value("userinfo").(Values).Get("WebhookSecret")
+		if t.Secret != nil {
+			secret = *t.Secret
+		}
+
+		// Media files are uploaded with the webhook unless set to url
+		media := t.Media
+		if media == "" {
+			media = r.Context().Value("userinfo").(Values).Get("WebhookMedia")
+		}
+		if media == "" {
+			media = "upload"
+		}
+		if media != "upload" && media != "url" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("media should be upload or url"))
+			return
+		}
+
+		// Events are sent as whatsmeow produces them unless the normalized schema is chosen
+		schema := t.Schema
+		if schema == "" {
+			schema = r.Context().Value("userinfo").(Values).Get("WebhookSchema")
+		}
+		if schema == "" {
+			schema = "legacy"
+		}
+		if schema != "legacy" && schema != "v1" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("schema should be legacy or v1"))
+			return
+		}
+		rawEvent := r.Context().Value("userinfo").(Values).Get("WebhookRaw") == "1"
+		if t.RawEvent != nil {
+			rawEvent = *t.RawEvent
+		}
+
+		_, err = s.db.Exec("UPDATE users SET webhook=?,webhook_format=?,webhook_secret=?,webhook_media=?,webhook_schema=?,webhook_raw=? WHERE id=?", webhook, format, secret, media, schema, rawEvent, userid)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("%s", err))
 			return
 		}
 
 		v := updateUserInfo(r.Context().Value("userinfo"), "Webhook", webhook)
+		v = updateUserInfo(v, "WebhookFormat", format)
+		v = updateUserInfo(v, "WebhookMedia", media)
+		v = updateUserInfo(v, "WebhookSchema", schema)
+		if rawEvent {
+			v = updateUserInfo(v, "WebhookRaw", "1")
+		} else {
+			v = updateUserInfo(v, "WebhookRaw", "0")
+		}
+		// Not using updateUserInfo so the secret does not end up in debug logs
+		v.(Values).m["WebhookSecret"] = secret
 		userinfocache.Set(token, v, cache.NoExpiration)
 
-		response := map[string]interface{}{"webhook": webhook}
+		response := map[string]interface{}{"webhook": webhook, "format": format, "signed": secret != "", "media": media, "schema": schema, "rawEvent": rawEvent}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
@@ -395,529 +505,387 @@ func (s *server) SetWebhook() http.HandlerFunc {
 	}
 }
 
-// Gets QR code encoded in Base64
-func (s *server) GetQR() http.HandlerFunc {
+// Lists additional webhook endpoints
+func (s *server) ListWebhookEndpoints() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		userid, _ := strconv.Atoi(txtid)
-		code := ""
 
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		endpoints, err := getWebhookEndpoints(s.db, userid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not list webhook endpoints: %v", err))
 			return
-		} else {
-			if !clientPointer[userid].IsConnected() {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New("not connected"))
-				return
-			}
-			rows, err := s.db.Query("SELECT qrcode AS code FROM users WHERE id=? LIMIT 1", userid)
-			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, err)
-				return
-			}
-			defer rows.Close()
-			for rows.Next() {
-				err = rows.Scan(&code)
-				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, err)
-					return
-				}
-			}
-			err = rows.Err()
-			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, err)
-				return
-			}
-			if clientPointer[userid].IsLoggedIn() {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New("already loggedin"))
-				return
-			}
 		}
 
-		log.Info().Str("userid", txtid).Str("qrcode", code).Msg("Get QR successful")
-		response := map[string]interface{}{"QRCode": code}
+		response := map[string]interface{}{"Endpoints": endpoints}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
-
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// Logs out device from Whatsapp (requires to scan QR next time)
-func (s *server) Logout() http.HandlerFunc {
+// Adds a webhook endpoint receiving the subscribed events
+func (s *server) AddWebhookEndpoint() http.HandlerFunc {
+
+	type endpointStruct struct {
+		Url      string
+		Events   []string
+		Format   string
+		Secret   string
+		Media    string
+		Schema   string
+		RawEvent bool
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-		jid := r.Context().Value("userinfo").(Values).Get("Jid")
 		userid, _ := strconv.Atoi(txtid)
 
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		decoder := json.NewDecoder(r.Body)
+		var t endpointStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
-		} else {
-			if clientPointer[userid].IsLoggedIn() && clientPointer[userid].IsConnected() {
-				err := clientPointer[userid].Logout()
-				if err != nil {
-					log.Error().Str("jid", jid).Msg("Could not perform logout")
-					s.Respond(w, r, http.StatusInternalServerError, errors.New("could not perform logout"))
-					return
-				} else {
-					log.Info().Str("jid", jid).Msg("Logged out")
-					killchannel[userid] <- true
-				}
-			} else {
-				if clientPointer[userid].IsConnected() {
-					log.Warn().Str("jid", jid).Msg("Ignoring logout as it was not logged in")
-					s.Respond(w, r, http.StatusInternalServerError, errors.New("could not disconnect as it was not logged in"))
-					return
-				} else {
-					log.Warn().Str("jid", jid).Msg("Ignoring logout as it was not connected")
-					s.Respond(w, r, http.StatusInternalServerError, errors.New("could not disconnect as it was not connected"))
-					return
-				}
-			}
 		}
 
-		response := map[string]interface{}{"Details": "Logged out"}
+		if t.Url == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Url in payload"))
+			return
+		}
+		if t.Format == "" {
+			t.Format = "json"
+		}
+		if t.Format != "form" && t.Format != "json" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("format should be form or json"))
+			return
+		}
+		if t.Media == "" {
+			t.Media = "upload"
+		}
+		if t.Media != "upload" && t.Media != "url" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("media should be upload or url"))
+			return
+		}
+		if t.Schema == "" {
+			t.Schema = "legacy"
+		}
+		if t.Schema != "legacy" && t.Schema != "v1" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("schema should be legacy or v1"))
+			return
+		}
+		events, err := parseWebhookEvents(t.Events)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		res, err := s.db.Exec(
+			"INSERT INTO webhooks(user_id,url,events,format,secret,media,schema,raw,created_at) VALUES(?,?,?,?,?,?,?,?,?)",
+			userid, t.Url, strings.Join(events, ","), t.Format, t.Secret, t.Media, t.Schema, t.RawEvent, time.Now().Unix(),
+		)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not add webhook endpoint: %v", err))
+			return
+		}
+		webhookcache.Delete(txtid)
+		id, _ := res.LastInsertId()
+
+		log.Info().Str("userid", txtid).Int64("id", id).Str("url", t.Url).Msg("Webhook endpoint added")
+		response := map[string]interface{}{"Id": id, "Url": t.Url, "Events": events, "Format": t.Format, "Signed": t.Secret != "", "Media": t.Media, "Schema": t.Schema, "RawEvent": t.RawEvent}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
-
-		s.Respond(w, r, http.StatusOK, string(responseJson))
+		s.Respond(w, r, http.StatusCreated, string(responseJson))
 	}
 }
 
-// Gets Connected and LoggedIn Status
-func (s *server) GetStatus() http.HandlerFunc {
-
+// Gets a webhook endpoint
+func (s *server) GetWebhookEndpoint() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		userid, _ := strconv.Atoi(txtid)
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid webhook id"))
 			return
 		}
 
-		isConnected := clientPointer[userid].IsConnected()
-		isLoggedIn := clientPointer[userid].IsLoggedIn()
-
-		response := map[string]interface{}{"Connected": isConnected, "LoggedIn": isLoggedIn}
-		responseJson, err := json.Marshal(response)
+		endpoints, err := getWebhookEndpoints(s.db, userid)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get webhook endpoint: %v", err))
 			return
 		}
-
-		s.Respond(w, r, http.StatusOK, string(responseJson))
+		for _, endpoint := range endpoints {
+			if endpoint.Id == id {
+				responseJson, err := json.Marshal(endpoint)
+				if err != nil {
+					s.Respond(w, r, http.StatusInternalServerError, err)
+					return
+				}
+				s.Respond(w, r, http.StatusOK, string(responseJson))
+				return
+			}
+		}
+		s.Respond(w, r, http.StatusNotFound, errors.New("webhook endpoint not found"))
 	}
 }
 
-// Sends a document/attachment message
-func (s *server) SendDocument() http.HandlerFunc {
-
-	type documentStruct struct {
-		Phone       string
-		Document    string
-		FileName    string
-		Id          string
-		ContextInfo waProto.ContextInfo
+// Updates a webhook endpoint, only the fields present in the payload are changed
+func (s *server) UpdateWebhookEndpoint() http.HandlerFunc {
+
+	type endpointStruct struct {
+		Url      *string
+		Events   []string
+		Format   *string
+		Secret   *string
+		Media    *string
+		Schema   *string
+		RawEvent *bool
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-		userid, _ := strconv.Atoi(txtid)
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid webhook id"))
 			return
 		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t documentStruct
-		err := decoder.Decode(&t)
+		var t endpointStruct
+		err = decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+		var url, events, format, secret, media, schema string
+		var rawEvent bool
+		err = s.db.QueryRow("SELECT url,events,format,secret,media,schema,raw FROM webhooks WHERE id=? AND user_id=?", id, txtid).Scan(&url, &events, &format, &secret, &media, &schema, &rawEvent)
+		if err == sql.ErrNoRows {
+			s.Respond(w, r, http.StatusNotFound, errors.New("webhook endpoint not found"))
 			return
-		}
-
-		if t.Document == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing document in payload"))
+		} else if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get webhook endpoint: %v", err))
 			return
 		}
 
-		if t.FileName == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing filename in payload"))
-			return
+		if t.Url != nil {
+			if *t.Url == "" {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("Url can not be empty"))
+				return
+			}
+			url = *t.Url
 		}
-
-		recipient, err := validateMessageFields(
-			t.Phone,
-			t.ContextInfo.StanzaId,
-			t.ContextInfo.Participant,
-		)
-		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
-			return
+		if t.Events != nil {
+			subscribed, err := parseWebhookEvents(t.Events)
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+			events = strings.Join(subscribed, ",")
 		}
-
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
+		if t.Format != nil {
+			if *t.Format != "form" && *t.Format != "json" {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("format should be form or json"))
+				return
+			}
+			format = *t.Format
 		}
-
-		var uploaded whatsmeow.UploadResponse
-		var filedata []byte
-
-		if t.Document[0:29] == "data:application/octet-stream" {
-			dataURL, err := dataurl.DecodeString(t.Document)
-			if err != nil {
-				s.Respond(
-					w,
-					r,
-					http.StatusBadRequest,
-					errors.New("could not decode base64 encoded data from payload"),
-				)
+		if t.Secret != nil {
+			secret = *t.Secret
+		}
+		if t.Media != nil {
+			if *t.Media != "upload" && *t.Media != "url" {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("media should be upload or url"))
 				return
-			} else {
-				filedata = dataURL.Data
-				uploaded, err = clientPointer[userid].Upload(context.Background(), filedata, whatsmeow.MediaDocument)
-				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("failed to upload file: %v", err))
-					return
-				}
 			}
-		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("document data should start with \"data:application/octet-stream;base64,\""))
-			return
+			media = *t.Media
 		}
-
-		msg := &waProto.Message{DocumentMessage: &waProto.DocumentMessage{
-			Url:           proto.String(uploaded.URL),
-			FileName:      &t.FileName,
-			DirectPath:    proto.String(uploaded.DirectPath),
-			MediaKey:      uploaded.MediaKey,
-			Mimetype:      proto.String(http.DetectContentType(filedata)),
-			FileEncSha256: uploaded.FileEncSHA256,
-			FileSha256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
-		}}
-
-		if t.ContextInfo.StanzaId != nil {
-			msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{
-				StanzaId:      proto.String(*t.ContextInfo.StanzaId),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
+		if t.Schema != nil {
+			if *t.Schema != "legacy" && *t.Schema != "v1" {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("schema should be legacy or v1"))
+				return
 			}
+			schema = *t.Schema
+		}
+		if t.RawEvent != nil {
+			rawEvent = *t.RawEvent
 		}
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		_, err = s.db.Exec("UPDATE webhooks SET url=?,events=?,format=?,secret=?,media=?,schema=?,raw=? WHERE id=? AND user_id=?", url, events, format, secret, media, schema, rawEvent, id, txtid)
 		if err != nil {
-			s.Respond(
-				w,
-				r,
-				http.StatusInternalServerError,
-				fmt.Errorf("error sending message: %v", err),
-			)
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not update webhook endpoint: %v", err))
 			return
 		}
+		webhookcache.Delete(txtid)
 
-		log.Info().
-			Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).
-			Str("id", msgid).
-			Msg("Message sent")
-		response := map[string]interface{}{
-			"Details":   "Sent",
-			"Timestamp": resp.Timestamp,
-			"Id":        msgid,
-		}
+		response := map[string]interface{}{"Id": id, "Url": url, "Events": strings.Split(events, ","), "Format": format, "Signed": secret != "", "Media": media, "Schema": schema, "RawEvent": rawEvent}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
-
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// Sends an audio message
-func (s *server) SendAudio() http.HandlerFunc {
-
-	type audioStruct struct {
-		Phone       string
-		Audio       string
-		Caption     string
-		Id          string
-		ContextInfo waProto.ContextInfo
-	}
-
+// Removes a webhook endpoint
+func (s *server) DeleteWebhookEndpoint() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-		userid, _ := strconv.Atoi(txtid)
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid webhook id"))
 			return
 		}
 
-		decoder := json.NewDecoder(r.Body)
-		var t audioStruct
-		err := decoder.Decode(&t)
+		res, err := s.db.Exec("DELETE FROM webhooks WHERE id=? AND user_id=?", id, txtid)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not delete webhook endpoint: %v", err))
 			return
 		}
-
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+		if n, _ := res.RowsAffected(); n == 0 {
+			s.Respond(w, r, http.StatusNotFound, errors.New("webhook endpoint not found"))
 			return
 		}
+		webhookcache.Delete(txtid)
 
-		if t.Audio == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing audio in payload"))
+		log.Info().Str("userid", txtid).Int64("id", id).Msg("Webhook endpoint deleted")
+		response := map[string]interface{}{"Details": "Webhook endpoint deleted", "Id": id}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Lists webhooks that could not be delivered
+func (s *server) ListFailedWebhooks() http.HandlerFunc {
+
+	type failedWebhook struct {
+		Id        int64
+		Url       string
+		Format    string
+		EventType string
+		Payload   string
+		Attempts  int
+		Error     string
+		CreatedAt time.Time
+		FailedAt  time.Time
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		recipient, err := validateMessageFields(
-			t.Phone,
-			t.ContextInfo.StanzaId,
-			t.ContextInfo.Participant,
+		rows, err := s.db.Query(
+			"SELECT id,url,format,event_type,payload,attempts,last_error,created_at,failed_at FROM webhook_deadletter WHERE user_id=? ORDER BY id",
+			txtid,
 		)
 		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not list failed webhooks: %v", err))
 			return
 		}
+		defer rows.Close()
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
-
-		var uploaded whatsmeow.UploadResponse
-		var filedata []byte
-
-		if t.Audio[0:14] == "data:audio/ogg" {
-			dataURL, err := dataurl.DecodeString(t.Audio)
+		webhooks := []failedWebhook{}
+		for rows.Next() {
+			var f failedWebhook
+			var createdAt, failedAt int64
+			err = rows.Scan(&f.Id, &f.Url, &f.Format, &f.EventType, &f.Payload, &f.Attempts, &f.Error, &createdAt, &failedAt)
 			if err != nil {
-				s.Respond(
-					w,
-					r,
-					http.StatusBadRequest,
-					errors.New("could not decode base64 encoded data from payload"),
-				)
+				s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not list failed webhooks: %v", err))
 				return
-			} else {
-				filedata = dataURL.Data
-				uploaded, err = clientPointer[userid].Upload(context.Background(), filedata, whatsmeow.MediaAudio)
-				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("failed to upload file: %v", err))
-					return
-				}
-			}
-		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("audio data should start with \"data:audio/ogg;base64,\""))
-			return
-		}
-
-		ptt := true
-		mime := "audio/ogg; codecs=opus"
-
-		msg := &waProto.Message{AudioMessage: &waProto.AudioMessage{
-			Url:        proto.String(uploaded.URL),
-			DirectPath: proto.String(uploaded.DirectPath),
-			MediaKey:   uploaded.MediaKey,
-			//Mimetype:      proto.String(http.DetectContentType(filedata)),
-			Mimetype:      &mime,
-			FileEncSha256: uploaded.FileEncSHA256,
-			FileSha256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
-			Ptt:           &ptt,
-		}}
-
-		if t.ContextInfo.StanzaId != nil {
-			msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{
-				StanzaId:      proto.String(*t.ContextInfo.StanzaId),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
 			}
+			f.CreatedAt = time.Unix(createdAt, 0)
+			f.FailedAt = time.Unix(failedAt, 0)
+			webhooks = append(webhooks, f)
 		}
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
-		if err != nil {
-			s.Respond(
-				w,
-				r,
-				http.StatusInternalServerError,
-				fmt.Errorf("error sending message: %v", err),
-			)
-			return
-		}
-
-		log.Info().
-			Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).
-			Str("id", msgid).
-			Msg("Message sent")
-		response := map[string]interface{}{
-			"Details":   "Sent",
-			"Timestamp": resp.Timestamp,
-			"Id":        msgid,
-		}
+		response := map[string]interface{}{"Webhooks": webhooks}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
-
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// Sends an Image message
-func (s *server) SendImage() http.HandlerFunc {
+// Replays failed webhooks, all of them if no Ids are given
+func (s *server) ReplayWebhooks() http.HandlerFunc {
 
-	type imageStruct struct {
-		Phone       string
-		Image       string
-		Caption     string
-		Id          string
-		ContextInfo waProto.ContextInfo
+	type replayStruct struct {
+		Ids []int64
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		userid, _ := strconv.Atoi(txtid)
-		msgid := ""
-		var resp whatsmeow.SendResponse
 
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
-			return
+		var t replayStruct
+		if r.ContentLength != 0 {
+			decoder := json.NewDecoder(r.Body)
+			err := decoder.Decode(&t)
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+				return
+			}
 		}
 
-		decoder := json.NewDecoder(r.Body)
-		var t imageStruct
-		err := decoder.Decode(&t)
+		replayed, err := replayWebhooks(s.db, userid, t.Ids)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not replay webhooks: %v", err))
 			return
 		}
 
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+		response := map[string]interface{}{"Details": "Webhooks queued for delivery", "Replayed": replayed}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
 
-		if t.Image == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing image in payload"))
-			return
-		}
+// Discards a failed webhook
+func (s *server) DeleteFailedWebhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 
-		recipient, err := validateMessageFields(
-			t.Phone,
-			t.ContextInfo.StanzaId,
-			t.ContextInfo.Participant,
-		)
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid webhook id"))
 			return
 		}
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
-
-		var uploaded whatsmeow.UploadResponse
-		var filedata []byte
-
-		if t.Image[0:10] == "data:image" {
-			dataURL, err := dataurl.DecodeString(t.Image)
-			if err != nil {
-				s.Respond(
-					w,
-					r,
-					http.StatusBadRequest,
-					errors.New("could not decode base64 encoded data from payload"),
-				)
-				return
-			} else {
-				filedata = dataURL.Data
-				uploaded, err = clientPointer[userid].Upload(context.Background(), filedata, whatsmeow.MediaImage)
-				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("failed to upload file: %v", err))
-					return
-				}
-			}
-		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("image data should start with \"data:image/png;base64,\""))
+		res, err := s.db.Exec("DELETE FROM webhook_deadletter WHERE id=? AND user_id=?", id, txtid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not delete webhook: %v", err))
 			return
 		}
-
-		msg := &waProto.Message{ImageMessage: &waProto.ImageMessage{
-			Caption:       proto.String(t.Caption),
-			Url:           proto.String(uploaded.URL),
-			DirectPath:    proto.String(uploaded.DirectPath),
-			MediaKey:      uploaded.MediaKey,
-			Mimetype:      proto.String(http.DetectContentType(filedata)),
-			FileEncSha256: uploaded.FileEncSHA256,
-			FileSha256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
-		}}
-
-		if t.ContextInfo.StanzaId != nil {
-			msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{
-				StanzaId:      proto.String(*t.ContextInfo.StanzaId),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
-			}
-		}
-
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
-		if err != nil {
-			s.Respond(
-				w,
-				r,
-				http.StatusInternalServerError,
-				fmt.Errorf("error sending message: %v", err),
-			)
+		if n, _ := res.RowsAffected(); n == 0 {
+			s.Respond(w, r, http.StatusNotFound, errors.New("webhook not found"))
 			return
 		}
 
-		log.Info().
-			Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).
-			Str("id", msgid).
-			Msg("Message sent")
-		response := map[string]interface{}{
-			"Details":   "Sent",
-			"Timestamp": resp.Timestamp,
-			"Id":        msgid,
-		}
+		response := map[string]interface{}{"Details": "Webhook deleted", "Id": id}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
@@ -927,129 +895,48 @@ func (s *server) SendImage() http.HandlerFunc {
 	}
 }
 
-// Sends Sticker message
-func (s *server) SendSticker() http.HandlerFunc {
-
-	type stickerStruct struct {
-		Phone        string
-		Sticker      string
-		Id           string
-		PngThumbnail []byte
-		ContextInfo  waProto.ContextInfo
-	}
-
+// Gets QR code encoded in Base64
+func (s *server) GetQR() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		userid, _ := strconv.Atoi(txtid)
-		msgid := ""
-		var resp whatsmeow.SendResponse
+		code := ""
 
 		if clientPointer[userid] == nil {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
 			return
-		}
-
-		decoder := json.NewDecoder(r.Body)
-		var t stickerStruct
-		err := decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
-			return
-		}
-
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
-			return
-		}
-
-		if t.Sticker == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing sticker in payload"))
-			return
-		}
-
-		recipient, err := validateMessageFields(
-			t.Phone,
-			t.ContextInfo.StanzaId,
-			t.ContextInfo.Participant,
-		)
-		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
-			return
-		}
-
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
 		} else {
-			msgid = t.Id
-		}
-
-		var uploaded whatsmeow.UploadResponse
-		var filedata []byte
-
-		if t.Sticker[0:4] == "data" {
-			dataURL, err := dataurl.DecodeString(t.Sticker)
+			if !clientPointer[userid].IsConnected() {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New("not connected"))
+				return
+			}
+			rows, err := s.db.Query("SELECT qrcode AS code FROM users WHERE id=? LIMIT 1", userid)
 			if err != nil {
-				s.Respond(
-					w,
-					r,
-					http.StatusBadRequest,
-					errors.New("could not decode base64 encoded data from payload"),
-				)
+				s.Respond(w, r, http.StatusInternalServerError, err)
 				return
-			} else {
-				filedata = dataURL.Data
-				uploaded, err = clientPointer[userid].Upload(context.Background(), filedata, whatsmeow.MediaImage)
+			}
+			defer rows.Close()
+			for rows.Next() {
+				err = rows.Scan(&code)
 				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("failed to upload file: %v", err))
+					s.Respond(w, r, http.StatusInternalServerError, err)
 					return
 				}
 			}
-		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("data should start with \"data:mime/type;base64,\""))
-			return
-		}
-
-		msg := &waProto.Message{StickerMessage: &waProto.StickerMessage{
-			Url:           proto.String(uploaded.URL),
-			DirectPath:    proto.String(uploaded.DirectPath),
-			MediaKey:      uploaded.MediaKey,
-			Mimetype:      proto.String(http.DetectContentType(filedata)),
-			FileEncSha256: uploaded.FileEncSHA256,
-			FileSha256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
-			PngThumbnail:  t.PngThumbnail,
-		}}
-
-		if t.ContextInfo.StanzaId != nil {
-			msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{
-				StanzaId:      proto.String(*t.ContextInfo.StanzaId),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
+			err = rows.Err()
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			if clientPointer[userid].IsLoggedIn() {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New("already loggedin"))
+				return
 			}
 		}
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
-		if err != nil {
-			s.Respond(
-				w,
-				r,
-				http.StatusInternalServerError,
-				fmt.Errorf("error sending message: %v", err),
-			)
-			return
-		}
-
-		log.Info().
-			Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).
-			Str("id", msgid).
-			Msg("Message sent")
-		response := map[string]interface{}{
-			"Details":   "Sent",
-			"Timestamp": resp.Timestamp,
-			"Id":        msgid,
-		}
+		log.Info().Str("userid", txtid).Str("qrcode", code).Msg("Get QR successful")
+		response := map[string]interface{}{"QRCode": code}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
@@ -1060,132 +947,198 @@ func (s *server) SendSticker() http.HandlerFunc {
 	}
 }
 
-// Sends Video message
-func (s *server) SendVideo() http.HandlerFunc {
-
-	type imageStruct struct {
-		Phone         string
-		Video         string
-		Caption       string
-		Id            string
-		JpegThumbnail []byte
-		ContextInfo   waProto.ContextInfo
-	}
-
+// Logs out device from Whatsapp (requires to scan QR next time)
+func (s *server) Logout() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		jid := r.Context().Value("userinfo").(Values).Get("Jid")
 		userid, _ := strconv.Atoi(txtid)
-		msgid := ""
-		var resp whatsmeow.SendResponse
 
 		if clientPointer[userid] == nil {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
 			return
+		} else {
+			if clientPointer[userid].IsLoggedIn() && clientPointer[userid].IsConnected() {
+				err := clientPointer[userid].Logout()
+				if err != nil {
+					log.Error().Str("jid", jid).Msg("Could not perform logout")
+					s.Respond(w, r, http.StatusInternalServerError, errors.New("could not perform logout"))
+					return
+				} else {
+					log.Info().Str("jid", jid).Msg("Logged out")
+					killchannel[userid] <- true
+				}
+			} else {
+				if clientPointer[userid].IsConnected() {
+					log.Warn().Str("jid", jid).Msg("Ignoring logout as it was not logged in")
+					s.Respond(w, r, http.StatusInternalServerError, errors.New("could not disconnect as it was not logged in"))
+					return
+				} else {
+					log.Warn().Str("jid", jid).Msg("Ignoring logout as it was not connected")
+					s.Respond(w, r, http.StatusInternalServerError, errors.New("could not disconnect as it was not connected"))
+					return
+				}
+			}
 		}
 
-		decoder := json.NewDecoder(r.Body)
-		var t imageStruct
-		err := decoder.Decode(&t)
+		response := map[string]interface{}{"Details": "Logged out"}
+		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
-			return
-		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Gets Connected and LoggedIn Status
+func (s *server) GetStatus() http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
 
-		if t.Video == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing video in payload"))
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
 			return
 		}
 
-		recipient, err := validateMessageFields(
-			t.Phone,
-			t.ContextInfo.StanzaId,
-			t.ContextInfo.Participant,
-		)
+		isConnected := clientPointer[userid].IsConnected()
+		isLoggedIn := clientPointer[userid].IsLoggedIn()
+
+		response := map[string]interface{}{"Connected": isConnected, "LoggedIn": isLoggedIn}
+		responseJson, err := json.Marshal(response)
 		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
 
-		var uploaded whatsmeow.UploadResponse
-		var filedata []byte
+// Sends a document/attachment message
+func (s *server) SendDocument() http.HandlerFunc {
+	return s.sendHandler("document")
+}
 
-		if t.Video[0:4] == "data" {
-			dataURL, err := dataurl.DecodeString(t.Video)
-			if err != nil {
-				s.Respond(
-					w,
-					r,
-					http.StatusBadRequest,
-					errors.New("could not decode base64 encoded data from payload"),
-				)
-				return
-			} else {
-				filedata = dataURL.Data
-				uploaded, err = clientPointer[userid].Upload(context.Background(), filedata, whatsmeow.MediaVideo)
-				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("failed to upload file: %v", err))
-					return
-				}
-			}
-		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("data should start with \"data:mime/type;base64,\""))
+// Sends an audio message
+func (s *server) SendAudio() http.HandlerFunc {
+	return s.sendHandler("audio")
+}
+
+// Sends an Image message
+func (s *server) SendImage() http.HandlerFunc {
+	return s.sendHandler("image")
+}
+
+// Sends Sticker message
+func (s *server) SendSticker() http.HandlerFunc {
+	return s.sendHandler("sticker")
+}
+
+// Sends Video message
+func (s *server) SendVideo() http.HandlerFunc {
+	return s.sendHandler("video")
+}
+
+// Sends Contact
+func (s *server) SendContact() http.HandlerFunc {
+	return s.sendHandler("contact")
+}
+
+// Sends location
+func (s *server) SendLocation() http.HandlerFunc {
+	return s.sendHandler("location")
+}
+
+// Sends Buttons (not implemented, does not work)
+func (s *server) SendButtons() http.HandlerFunc {
+	return s.sendHandler("buttons")
+}
+
+// Sends a poll, votes are received with the PollVote event
+func (s *server) SendPoll() http.HandlerFunc {
+	return s.sendHandler("poll")
+}
+
+// SendList
+// https://github.com/tulir/whatsmeow/issues/305
+func (s *server) SendList() http.HandlerFunc {
+	return s.sendHandler("list")
+}
+
+// Sends a regular text message
+func (s *server) SendMessage() http.HandlerFunc {
+	return s.sendHandler("text")
+}
+
+// checks if users/phones are on Whatsapp
+func (s *server) CheckUser() http.HandlerFunc {
+
+	type checkUserStruct struct {
+		Phone []string
+	}
+
+	type User struct {
+		Query        string
+		IsInWhatsapp bool
+		JID          string
+		VerifiedName string
+	}
+
+	type UserCollection struct {
+		Users []User
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
 			return
 		}
 
-		msg := &waProto.Message{VideoMessage: &waProto.VideoMessage{
-			Caption:       proto.String(t.Caption),
-			Url:           proto.String(uploaded.URL),
-			DirectPath:    proto.String(uploaded.DirectPath),
-			MediaKey:      uploaded.MediaKey,
-			Mimetype:      proto.String(http.DetectContentType(filedata)),
-			FileEncSha256: uploaded.FileEncSHA256,
-			FileSha256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
-			JpegThumbnail: t.JpegThumbnail,
-		}}
+		decoder := json.NewDecoder(r.Body)
+		var t checkUserStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
 
-		if t.ContextInfo.StanzaId != nil {
-			msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{
-				StanzaId:      proto.String(*t.ContextInfo.StanzaId),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
-			}
+		if len(t.Phone) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+			return
 		}
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		resp, err := clientPointer[userid].IsOnWhatsApp(t.Phone)
 		if err != nil {
 			s.Respond(
 				w,
 				r,
 				http.StatusInternalServerError,
-				fmt.Errorf("error sending message: %v", err),
+				fmt.Errorf("failed to check if users are on whatsapp: %s", err),
 			)
 			return
 		}
 
-		log.Info().
-			Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).
-			Str("id", msgid).
-			Msg("Message sent")
-		response := map[string]interface{}{
-			"Details":   "Sent",
-			"Timestamp": resp.Timestamp,
-			"Id":        msgid,
+		uc := new(UserCollection)
+		for _, item := range resp {
+			if item.VerifiedName != nil {
+				var msg = User{Query: item.Query, IsInWhatsapp: item.IsIn, JID: item.JID.String(), VerifiedName: item.VerifiedName.Details.GetVerifiedName()}
+				uc.Users = append(uc.Users, msg)
+			} else {
+				var msg = User{Query: item.Query, IsInWhatsapp: item.IsIn, JID: item.JID.String(), VerifiedName: ""}
+				uc.Users = append(uc.Users, msg)
+			}
 		}
-		responseJson, err := json.Marshal(response)
+		responseJson, err := json.Marshal(uc)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
@@ -1194,15 +1147,15 @@ func (s *server) SendVideo() http.HandlerFunc {
 	}
 }
 
-// Sends Contact
-func (s *server) SendContact() http.HandlerFunc {
+// Gets user information
+func (s *server) GetUser() http.HandlerFunc {
 
-	type contactStruct struct {
-		Phone       string
-		Id          string
-		Name        string
-		Vcard       string
-		ContextInfo waProto.ContextInfo
+	type checkUserStruct struct {
+		Phone []string
+	}
+
+	type UserCollection struct {
+		Users map[types.JID]types.UserInfo
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1215,99 +1168,58 @@ func (s *server) SendContact() http.HandlerFunc {
 			return
 		}
 
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
 		decoder := json.NewDecoder(r.Body)
-		var t contactStruct
+		var t checkUserStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
-			return
-		}
-		if t.Name == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing name in payload"))
-			return
-		}
-		if t.Vcard == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing vcard in payload"))
-			return
-		}
 
-		recipient, err := validateMessageFields(
-			t.Phone,
-			t.ContextInfo.StanzaId,
-			t.ContextInfo.Participant,
-		)
-		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+		if len(t.Phone) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
 			return
 		}
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
-
-		msg := &waProto.Message{ContactMessage: &waProto.ContactMessage{
-			DisplayName: &t.Name,
-			Vcard:       &t.Vcard,
-		}}
-
-		if t.ContextInfo.StanzaId != nil {
-			msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{
-				StanzaId:      proto.String(*t.ContextInfo.StanzaId),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
+		var jids []types.JID
+		for _, arg := range t.Phone {
+			jid, ok := parseJID(arg)
+			if !ok {
+				return
 			}
+			jids = append(jids, jid)
 		}
+		resp, err := clientPointer[userid].GetUserInfo(jids)
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
 		if err != nil {
-			s.Respond(
-				w,
-				r,
-				http.StatusInternalServerError,
-				fmt.Errorf("error sending message: %v", err),
-			)
+			msg := fmt.Sprintf("Failed to get user info: %v", err)
+			log.Error().Msg(msg)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		log.Info().
-			Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).
-			Str("id", msgid).
-			Msg("Message sent")
-		response := map[string]interface{}{
-			"Details":   "Sent",
-			"Timestamp": resp.Timestamp,
-			"Id":        msgid,
+		uc := new(UserCollection)
+		uc.Users = make(map[types.JID]types.UserInfo)
+
+		for jid, info := range resp {
+			uc.Users[jid] = info
 		}
-		responseJson, err := json.Marshal(response)
+
+		responseJson, err := json.Marshal(uc)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
-
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// Sends location
-func (s *server) SendLocation() http.HandlerFunc {
+// Gets avatar info for user
+func (s *server) GetAvatar() http.HandlerFunc {
 
-	type locationStruct struct {
-		Phone       string
-		Id          string
-		Name        string
-		Latitude    float64
-		Longitude   float64
-		ContextInfo waProto.ContextInfo
+	type getAvatarStruct struct {
+		Phone   string
+		Preview bool
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1320,104 +1232,60 @@ func (s *server) SendLocation() http.HandlerFunc {
 			return
 		}
 
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
 		decoder := json.NewDecoder(r.Body)
-		var t locationStruct
+		var t getAvatarStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
-		if t.Phone == "" {
+
+		if len(t.Phone) < 1 {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
 			return
 		}
-		if t.Latitude == 0 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing latitude in payload"))
-			return
-		}
-		if t.Longitude == 0 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing longitude in payload"))
-			return
-		}
 
-		recipient, err := validateMessageFields(
-			t.Phone,
-			t.ContextInfo.StanzaId,
-			t.ContextInfo.Participant,
-		)
-		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+		jid, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
 			return
 		}
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
-
-		msg := &waProto.Message{LocationMessage: &waProto.LocationMessage{
-			DegreesLatitude:  &t.Latitude,
-			DegreesLongitude: &t.Longitude,
-			Name:             &t.Name,
-		}}
-
-		if t.ContextInfo.StanzaId != nil {
-			msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{
-				StanzaId:      proto.String(*t.ContextInfo.StanzaId),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
-			}
-		}
+		var pic *types.ProfilePictureInfo
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		existingID := ""
+		pic, err = clientPointer[userid].GetProfilePictureInfo(
+			jid,
+			&whatsmeow.GetProfilePictureParams{
+				Preview:    t.Preview,
+				ExistingID: existingID,
+			},
+		)
 		if err != nil {
-			s.Respond(
-				w,
-				r,
-				http.StatusInternalServerError,
-				fmt.Errorf("error sending message: %v", err),
-			)
+			msg := fmt.Sprintf("Failed to get avatar: %v", err)
+			log.Error().Msg(msg)
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
 			return
 		}
 
-		log.Info().
-			Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).
-			Str("id", msgid).
-			Msg("Message sent")
-		response := map[string]interface{}{
-			"Details":   "Sent",
-			"Timestamp": resp.Timestamp,
-			"Id":        msgid,
+		if pic == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no avatar found"))
+			return
 		}
-		responseJson, err := json.Marshal(response)
+
+		log.Info().Str("id", pic.ID).Str("url", pic.URL).Msg("Got avatar")
+
+		responseJson, err := json.Marshal(pic)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
-
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// Sends Buttons (not implemented, does not work)
-
-func (s *server) SendButtons() http.HandlerFunc {
-
-	type buttonStruct struct {
-		ButtonId   string
-		ButtonText string
-	}
-	type textStruct struct {
-		Phone   string
-		Title   string
-		Buttons []buttonStruct
-		Id      string
-	}
+// Gets all contacts
+func (s *server) GetContacts() http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -1429,127 +1297,29 @@ func (s *server) SendButtons() http.HandlerFunc {
 			return
 		}
 
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
-		decoder := json.NewDecoder(r.Body)
-		var t textStruct
-		err := decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
-			return
-		}
-
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
-			return
-		}
-
-		if t.Title == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing title in payload"))
-			return
-		}
-
-		if len(t.Buttons) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Buttons in Payload"))
-			return
-		}
-		if len(t.Buttons) > 3 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("buttons cant more than 3"))
-			return
-		}
-
-		recipient, ok := parseJID(t.Phone)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
-			return
-		}
-
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
-
-		var buttons []*waProto.ButtonsMessage_Button
-
-		for _, item := range t.Buttons {
-			buttons = append(buttons, &waProto.ButtonsMessage_Button{
-				ButtonId: proto.String(item.ButtonId),
-				ButtonText: &waProto.ButtonsMessage_Button_ButtonText{
-					DisplayText: proto.String(item.ButtonText),
-				},
-				Type:           waProto.ButtonsMessage_Button_RESPONSE.Enum(),
-				NativeFlowInfo: &waProto.ButtonsMessage_Button_NativeFlowInfo{},
-			})
-		}
-
-		msg2 := &waProto.ButtonsMessage{
-			ContentText: proto.String(t.Title),
-			HeaderType:  waProto.ButtonsMessage_EMPTY.Enum(),
-			Buttons:     buttons,
-		}
-
-		resp, err = clientPointer[userid].SendMessage(
-			context.Background(),
-			recipient,
-			&waProto.Message{ViewOnceMessage: &waProto.FutureProofMessage{
-				Message: &waProto.Message{
-					ButtonsMessage: msg2,
-				},
-			}},
-		)
+		result, err := clientPointer[userid].Store.Contacts.GetAllContacts()
 		if err != nil {
-			s.Respond(
-				w,
-				r,
-				http.StatusInternalServerError,
-				fmt.Errorf("error sending message: %v", err),
-			)
+			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		log.Info().
-			Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).
-			Str("id", msgid).
-			Msg("Message sent")
-		response := map[string]interface{}{
-			"Details":   "Sent",
-			"Timestamp": resp.Timestamp,
-			"Id":        msgid,
-		}
-		responseJson, err := json.Marshal(response)
+		responseJson, err := json.Marshal(result)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
+
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// SendList
-// https://github.com/tulir/whatsmeow/issues/305
-func (s *server) SendList() http.HandlerFunc {
-
-	type rowsStruct struct {
-		RowId       string
-		Title       string
-		Description string
-	}
-
-	type sectionsStruct struct {
-		Title string
-		Rows  []rowsStruct
-	}
+// Sets Chat Presence (typing/paused/recording audio)
+func (s *server) ChatPresence() http.HandlerFunc {
 
-	type listStruct struct {
-		Phone       string
-		Title       string
-		Description string
-		ButtonText  string
-		FooterText  string
-		Sections    []sectionsStruct
-		Id          string
+	type chatPresenceStruct struct {
+		Phone string
+		State string
+		Media types.ChatPresenceMedia
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1562,118 +1332,46 @@ func (s *server) SendList() http.HandlerFunc {
 			return
 		}
 
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
 		decoder := json.NewDecoder(r.Body)
-		var t listStruct
+		var t chatPresenceStruct
 		err := decoder.Decode(&t)
-		marshal, _ := json.Marshal(t)
-		fmt.Println(string(marshal))
 		if err != nil {
-			fmt.Println(err)
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
-			return
-		}
-
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
-			return
-		}
-
-		if t.Title == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Title in Payload"))
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		if t.Description == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Description in Payload"))
+		if len(t.Phone) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
 			return
 		}
 
-		if t.ButtonText == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing ButtonText in Payload"))
+		if len(t.State) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing state in payload"))
 			return
 		}
 
-		if len(t.Sections) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Sections in Payload"))
-			return
-		}
-		recipient, ok := parseJID(t.Phone)
+		jid, ok := parseJID(t.Phone)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
 			return
 		}
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
-
-		var sections []*waProto.ListMessage_Section
-
-		for _, item := range t.Sections {
-			var rows []*waProto.ListMessage_Row
-			id := 1
-			for _, row := range item.Rows {
-				var idtext string
-				if row.RowId == "" {
-					idtext = strconv.Itoa(id)
-				} else {
-					idtext = row.RowId
-				}
-				rows = append(rows, &waProto.ListMessage_Row{
-					RowId:       proto.String(idtext),
-					Title:       proto.String(row.Title),
-					Description: proto.String(row.Description),
-				})
-			}
-
-			sections = append(sections, &waProto.ListMessage_Section{
-				Title: proto.String(item.Title),
-				Rows:  rows,
-			})
-		}
-		msg1 := &waProto.ListMessage{
-			Title:       proto.String(t.Title),
-			Description: proto.String(t.Description),
-			ButtonText:  proto.String(t.ButtonText),
-			ListType:    waProto.ListMessage_SINGLE_SELECT.Enum(),
-			Sections:    sections,
-			FooterText:  proto.String(t.FooterText),
-		}
-
-		resp, err = clientPointer[userid].SendMessage(
-			context.Background(),
-			recipient,
-			&waProto.Message{
-				ViewOnceMessage: &waProto.FutureProofMessage{
-					Message: &waProto.Message{
-						ListMessage: msg1,
-					},
-				}},
+		err = clientPointer[userid].SendChatPresence(
+			jid,
+			types.ChatPresence(t.State),
+			types.ChatPresenceMedia(t.Media),
 		)
 		if err != nil {
 			s.Respond(
 				w,
 				r,
 				http.StatusInternalServerError,
-				fmt.Errorf("error sending message: %v", err),
+				errors.New("failure sending chat presence to whatsapp servers"),
 			)
 			return
 		}
 
-		log.Info().
-			Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).
-			Str("id", msgid).
-			Msg("Message sent")
-		response := map[string]interface{}{
-			"Details":   "Sent",
-			"Timestamp": resp.Timestamp,
-			"Id":        msgid,
-		}
+		response := map[string]interface{}{"Details": "Chat presence set successfuly"}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
@@ -1684,14 +1382,17 @@ func (s *server) SendList() http.HandlerFunc {
 	}
 }
 
-// Sends a regular text message
-func (s *server) SendMessage() http.HandlerFunc {
+// Downloads Image and returns base64 representation
+func (s *server) DownloadImage() http.HandlerFunc {
 
-	type textStruct struct {
-		Phone       string
-		Body        string
-		Id          string
-		ContextInfo waProto.ContextInfo
+	type downloadImageStruct struct {
+		Url           string
+		DirectPath    string
+		MediaKey      []byte
+		Mimetype      string
+		FileEncSHA256 []byte
+		FileSHA256    []byte
+		FileLength    uint64
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1699,86 +1400,1323 @@ func (s *server) SendMessage() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		userid, _ := strconv.Atoi(txtid)
 
+		mimetype := ""
+		var imgdata []byte
+
 		if clientPointer[userid] == nil {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
 			return
 		}
 
-		msgid := ""
-		var resp whatsmeow.SendResponse
+		// check/creates user directory for files
+		userDirectory := fmt.Sprintf("%s/files/user_%s", s.exPath, txtid)
+		_, err := os.Stat(userDirectory)
+		if os.IsNotExist(err) {
+			errDir := os.MkdirAll(userDirectory, 0751)
+			if errDir != nil {
+				s.Respond(
+					w,
+					r,
+					http.StatusInternalServerError,
+					fmt.Errorf("could not create user directory (%s)", userDirectory),
+				)
+				return
+			}
+		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t textStruct
-		err := decoder.Decode(&t)
+		var t downloadImageStruct
+		err = decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
-			return
-		}
+		msg := &waProto.Message{ImageMessage: &waProto.ImageMessage{
+			Url:           proto.String(t.Url),
+			DirectPath:    proto.String(t.DirectPath),
+			MediaKey:      t.MediaKey,
+			Mimetype:      proto.String(t.Mimetype),
+			FileEncSha256: t.FileEncSHA256,
+			FileSha256:    t.FileSHA256,
+			FileLength:    &t.FileLength,
+		}}
 
-		if t.Body == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing body in payload"))
-			return
+		img := msg.GetImageMessage()
+
+		if img != nil {
+			imgdata, err = clientPointer[userid].Download(img)
+			if err != nil {
+				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to download image")
+				msg := fmt.Sprintf("Failed to download image %v", err)
+				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
+				return
+			}
+			mimetype = img.GetMimetype()
 		}
 
-		recipient, err := validateMessageFields(
-			t.Phone,
-			t.ContextInfo.StanzaId,
-			t.ContextInfo.Participant,
-		)
+		dataURL := dataurl.New(imgdata, mimetype)
+		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
+		responseJson, err := json.Marshal(response)
 		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
+// Downloads Document and returns base64 representation
+func (s *server) DownloadDocument() http.HandlerFunc {
 
-		//	msg := &waProto.Message{Conversation: &t.Body}
+	type downloadDocumentStruct struct {
+		Url           string
+		DirectPath    string
+		MediaKey      []byte
+		Mimetype      string
+		FileEncSHA256 []byte
+		FileSHA256    []byte
+		FileLength    uint64
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		mimetype := ""
+		var docdata []byte
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		// check/creates user directory for files
+		userDirectory := fmt.Sprintf("%s/files/user_%s", s.exPath, txtid)
+		_, err := os.Stat(userDirectory)
+		if os.IsNotExist(err) {
+			errDir := os.MkdirAll(userDirectory, 0751)
+			if errDir != nil {
+				s.Respond(
+					w,
+					r,
+					http.StatusInternalServerError,
+					fmt.Errorf("could not create user directory (%s)", userDirectory),
+				)
+				return
+			}
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t downloadDocumentStruct
+		err = decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		msg := &waProto.Message{DocumentMessage: &waProto.DocumentMessage{
+			Url:           proto.String(t.Url),
+			DirectPath:    proto.String(t.DirectPath),
+			MediaKey:      t.MediaKey,
+			Mimetype:      proto.String(t.Mimetype),
+			FileEncSha256: t.FileEncSHA256,
+			FileSha256:    t.FileSHA256,
+			FileLength:    &t.FileLength,
+		}}
+
+		doc := msg.GetDocumentMessage()
+
+		if doc != nil {
+			docdata, err = clientPointer[userid].Download(doc)
+			if err != nil {
+				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to download document")
+				msg := fmt.Sprintf("Failed to download document %v", err)
+				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
+				return
+			}
+			mimetype = doc.GetMimetype()
+		}
+
+		dataURL := dataurl.New(docdata, mimetype)
+		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Downloads Video and returns base64 representation
+func (s *server) DownloadVideo() http.HandlerFunc {
+
+	type downloadVideoStruct struct {
+		Url           string
+		DirectPath    string
+		MediaKey      []byte
+		Mimetype      string
+		FileEncSHA256 []byte
+		FileSHA256    []byte
+		FileLength    uint64
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		mimetype := ""
+		var docdata []byte
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		// check/creates user directory for files
+		userDirectory := fmt.Sprintf("%s/files/user_%s", s.exPath, txtid)
+		_, err := os.Stat(userDirectory)
+		if os.IsNotExist(err) {
+			errDir := os.MkdirAll(userDirectory, 0751)
+			if errDir != nil {
+				s.Respond(
+					w,
+					r,
+					http.StatusInternalServerError,
+					fmt.Errorf("could not create user directory (%s)", userDirectory),
+				)
+				return
+			}
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t downloadVideoStruct
+		err = decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		msg := &waProto.Message{VideoMessage: &waProto.VideoMessage{
+			Url:           proto.String(t.Url),
+			DirectPath:    proto.String(t.DirectPath),
+			MediaKey:      t.MediaKey,
+			Mimetype:      proto.String(t.Mimetype),
+			FileEncSha256: t.FileEncSHA256,
+			FileSha256:    t.FileSHA256,
+			FileLength:    &t.FileLength,
+		}}
+
+		doc := msg.GetVideoMessage()
+
+		if doc != nil {
+			docdata, err = clientPointer[userid].Download(doc)
+			if err != nil {
+				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to download video")
+				msg := fmt.Sprintf("Failed to download video %v", err)
+				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
+				return
+			}
+			mimetype = doc.GetMimetype()
+		}
+
+		dataURL := dataurl.New(docdata, mimetype)
+		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Downloads Audio and returns base64 representation
+func (s *server) DownloadAudio() http.HandlerFunc {
+
+	type downloadAudioStruct struct {
+		Url           string
+		DirectPath    string
+		MediaKey      []byte
+		Mimetype      string
+		FileEncSHA256 []byte
+		FileSHA256    []byte
+		FileLength    uint64
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		mimetype := ""
+		var docdata []byte
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		// check/creates user directory for files
+		userDirectory := fmt.Sprintf("%s/files/user_%s", s.exPath, txtid)
+		_, err := os.Stat(userDirectory)
+		if os.IsNotExist(err) {
+			errDir := os.MkdirAll(userDirectory, 0751)
+			if errDir != nil {
+				s.Respond(
+					w,
+					r,
+					http.StatusInternalServerError,
+					fmt.Errorf("could not create user directory (%s)", userDirectory),
+				)
+				return
+			}
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t downloadAudioStruct
+		err = decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		msg := &waProto.Message{AudioMessage: &waProto.AudioMessage{
+			Url:           proto.String(t.Url),
+			DirectPath:    proto.String(t.DirectPath),
+			MediaKey:      t.MediaKey,
+			Mimetype:      proto.String(t.Mimetype),
+			FileEncSha256: t.FileEncSHA256,
+			FileSha256:    t.FileSHA256,
+			FileLength:    &t.FileLength,
+		}}
+
+		doc := msg.GetAudioMessage()
+
+		if doc != nil {
+			docdata, err = clientPointer[userid].Download(doc)
+			if err != nil {
+				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to download audio")
+				msg := fmt.Sprintf("Failed to download audio %v", err)
+				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
+				return
+			}
+			mimetype = doc.GetMimetype()
+		}
+
+		dataURL := dataurl.New(docdata, mimetype)
+		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// React
+func (s *server) React() http.HandlerFunc {
+
+	type textStruct struct {
+		Phone string
+		Body  string
+		Id    string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		msgid := ""
+		var resp whatsmeow.SendResponse
+
+		decoder := json.NewDecoder(r.Body)
+		var t textStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.Phone == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+			return
+		}
+
+		if t.Body == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing body in payload"))
+			return
+		}
+
+		recipient, ok := parseJID(t.Phone)
+		if !ok {
+			log.Error().Msg(fmt.Sprintf("%s", err))
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		if t.Id == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing id in payload"))
+			return
+		} else {
+			msgid = t.Id
+		}
+
+		fromMe := false
+		if strings.HasPrefix(msgid, "me:") {
+			fromMe = true
+			msgid = msgid[len("me:"):]
+		}
+		reaction := t.Body
+		if reaction == "remove" {
+			reaction = ""
+		}
+
+		msg := &waProto.Message{
+			ReactionMessage: &waProto.ReactionMessage{
+				Key: &waProto.MessageKey{
+					RemoteJid: proto.String(recipient.String()),
+					FromMe:    proto.Bool(fromMe),
+					Id:        proto.String(msgid),
+				},
+				Text:              proto.String(reaction),
+				GroupingKey:       proto.String(reaction),
+				SenderTimestampMs: proto.Int64(time.Now().UnixMilli()),
+			},
+		}
+
+		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		if err != nil {
+			s.Respond(
+				w,
+				r,
+				http.StatusInternalServerError,
+				fmt.Errorf("error sending message: %v", err),
+			)
+			return
+		}
+
+		log.Info().
+			Str("timestamp", fmt.Sprintf("%d", resp.Timestamp.Unix())).
+			Str("id", msgid).
+			Msg("Message sent")
+		response := map[string]interface{}{
+			"Details":   "Sent",
+			"Timestamp": resp.Timestamp,
+			"Id":        msgid,
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Edits the text of a sent message
+func (s *server) EditMessage() http.HandlerFunc {
+
+	type editStruct struct {
+		Phone      string
+		Id         string
+		Body       string
+		Mentions   []string
+		MentionAll bool
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t editStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.Phone == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+			return
+		}
+
+		if t.Id == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing id in payload"))
+			return
+		}
+
+		if t.Body == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing body in payload"))
+			return
+		}
+
+		recipient, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+			return
+		}
+
+		req := &sendRequest{Type: "text", Body: t.Body, Mentions: t.Mentions, MentionAll: t.MentionAll}
+		newContent, status, err := editContent(s.db, userid, clientPointer[userid], recipient, t.Id, req)
+		if err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
+		msg := clientPointer[userid].BuildEdit(recipient, t.Id, newContent)
+		resp, err := clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("error sending edit: %v", err))
+			return
+		}
+
+		err = editStoredMessage(s.db, userid, recipient, t.Id, newContent)
+		if err != nil {
+			log.Warn().Err(err).Str("id", t.Id).Msg("Could not store edited message")
+		}
+
+		log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp.Unix())).Str("id", t.Id).Msg("Message edited")
+		response := map[string]interface{}{"Details": "Edited", "Timestamp": resp.Timestamp, "Id": t.Id}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Revokes a message for everyone, group admins can revoke messages from other participants
+func (s *server) RevokeMessage() http.HandlerFunc {
+
+	type revokeStruct struct {
+		Phone       string
+		Id          string
+		Participant string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t revokeStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.Phone == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+			return
+		}
+
+		if t.Id == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing id in payload"))
+			return
+		}
+
+		recipient, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+			return
+		}
+
+		// Own messages are revoked with an empty sender
+		sender := types.EmptyJID
+		if t.Participant != "" {
+			if recipient.Server != types.GroupServer {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("participant can only be set for group messages"))
+				return
+			}
+			sender, ok = parseJID(t.Participant)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse participant"))
+				return
+			}
+		}
+
+		msg := clientPointer[userid].BuildRevoke(recipient, sender, t.Id)
+		resp, err := clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("error sending revoke: %v", err))
+			return
+		}
+
+		err = revokeStoredMessage(s.db, userid, recipient, t.Id)
+		if err != nil {
+			log.Warn().Err(err).Str("id", t.Id).Msg("Could not mark message as revoked")
+		}
+
+		log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp.Unix())).Str("id", t.Id).Msg("Message revoked")
+		response := map[string]interface{}{"Details": "Revoked", "Timestamp": resp.Timestamp, "Id": t.Id}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Mark messages as read
+func (s *server) MarkRead() http.HandlerFunc {
+
+	type markReadStruct struct {
+		Id     []string
+		Chat   types.JID
+		Sender types.JID
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t markReadStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.Chat.String() == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing chat in payload"))
+			return
+		}
+
+		if len(t.Id) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing id in payload"))
+			return
+		}
+
+		err = clientPointer[userid].MarkRead(t.Id, time.Now(), t.Chat, t.Sender)
+		if err != nil {
+			s.Respond(
+				w,
+				r,
+				http.StatusInternalServerError,
+				errors.New("failure marking messages as read"),
+			)
+			return
+		}
+		err = markChatRead(s.db, userid, t.Chat)
+		if err != nil {
+			log.Warn().Err(err).Str("chat", t.Chat.String()).Msg("Could not update chat")
+		}
+
+		response := map[string]interface{}{"Details": "Message(s) marked as read"}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// List groups
+func (s *server) ListGroups() http.HandlerFunc {
+
+	type GroupCollection struct {
+		Groups []types.GroupInfo
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		resp, err := clientPointer[userid].GetJoinedGroups()
+
+		if err != nil {
+			msg := fmt.Sprintf("Failed to get group list: %v", err)
+			log.Error().Msg(msg)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		gc := new(GroupCollection)
+		for _, info := range resp {
+			gc.Groups = append(gc.Groups, *info)
+		}
+
+		responseJson, err := json.Marshal(gc)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Get group info
+func (s *server) GetGroupInfo() http.HandlerFunc {
+
+	type getGroupInfoStruct struct {
+		GroupJID string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t getGroupInfoStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		info, err := clientPointer[userid].GetGroupInfo(group)
+
+		if err != nil {
+			msg := fmt.Sprintf("Failed to get group info: %v", err)
+			log.Error().Msg(msg)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		// Communities also list their linked groups, linked groups have LinkedParentJID set
+		var resp interface{} = info
+		if info.IsParent {
+			resp, err = getCommunity(clientPointer[userid], info)
+			if err != nil {
+				msg := fmt.Sprintf("Failed to get community groups: %v", err)
+				log.Error().Msg(msg)
+				s.Respond(w, r, http.StatusInternalServerError, msg)
+				return
+			}
+		}
+
+		responseJson, err := json.Marshal(resp)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Get group invite link
+func (s *server) GetGroupInviteLink() http.HandlerFunc {
+
+	type getGroupInfoStruct struct {
+		GroupJID string
+		Reset    bool
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t getGroupInfoStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		resp, err := clientPointer[userid].GetGroupInviteLink(group, t.Reset)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to get group invite link")
+			msg := fmt.Sprintf("Failed to get group invite link: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		response := map[string]interface{}{"InviteLink": resp}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Set group photo
+func (s *server) SetGroupPhoto() http.HandlerFunc {
+
+	type setGroupPhotoStruct struct {
+		GroupJID string
+		Image    string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t setGroupPhotoStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		if t.Image == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing image in payload"))
+			return
+		}
+
+		var filedata []byte
+
+		if t.Image[0:13] == "data:image/jp" {
+			dataURL, err := dataurl.DecodeString(t.Image)
+			if err != nil {
+				s.Respond(
+					w,
+					r,
+					http.StatusBadRequest,
+					errors.New("could not decode base64 encoded data from payload"),
+				)
+				return
+			} else {
+				filedata = dataURL.Data
+			}
+		} else {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("image data should start with \"data:image/jpeg;base64,\""))
+			return
+		}
+
+		picture_id, err := clientPointer[userid].SetGroupPhoto(group, filedata)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group photo")
+			msg := fmt.Sprintf("Failed to set group photo: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		response := map[string]interface{}{
+			"Details":   "Group Photo set successfully",
+			"PictureID": picture_id,
+		}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Set group name
+func (s *server) SetGroupName() http.HandlerFunc {
+
+	type setGroupNameStruct struct {
+		GroupJID string
+		Name     string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t setGroupNameStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		if t.Name == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing name in payload"))
+			return
+		}
+
+		err = clientPointer[userid].SetGroupName(group, t.Name)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group name")
+			msg := fmt.Sprintf("Failed to set group name: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Group Name set successfully"}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Create group
+func (s *server) CreateGroup() http.HandlerFunc {
+
+	type createGroupStruct struct {
+		Name         string
+		Participants []string
+		CommunityJID string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t createGroupStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.Name == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing name in payload"))
+			return
+		}
+
+		participants, err := parseParticipants(t.Participants)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		req := whatsmeow.ReqCreateGroup{Name: t.Name, Participants: participants}
+		if t.CommunityJID != "" {
+			community, ok := parseJID(t.CommunityJID)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse community jid"))
+				return
+			}
+			req.LinkedParentJID = community
+		}
+
+		response, err := clientPointer[userid].CreateGroup(req)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to create group")
+			msg := fmt.Sprintf("Failed to create group: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Add, remove, promote or demote group participants
+func (s *server) UpdateGroupParticipants() http.HandlerFunc {
+
+	type groupParticipantsStruct struct {
+		GroupJID     string
+		Action       string
+		Participants []string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t groupParticipantsStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		if !Find(participantActions, t.Action) {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("action should be add, remove, promote or demote"))
+			return
+		}
+
+		if len(t.Participants) == 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing participants in payload"))
+			return
+		}
+
+		participants, err := parseParticipants(t.Participants)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		changes := make(map[types.JID]whatsmeow.ParticipantChange)
+		for _, participant := range participants {
+			changes[participant] = whatsmeow.ParticipantChange(t.Action)
+		}
+
+		resp, err := clientPointer[userid].UpdateGroupParticipants(group, changes)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to update group participants")
+			msg := fmt.Sprintf("Failed to update group participants: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		response := map[string]interface{}{"Participants": parseParticipantResults(resp)}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Set group topic, an empty topic removes it
+func (s *server) SetGroupTopic() http.HandlerFunc {
+
+	type setGroupTopicStruct struct {
+		GroupJID string
+		Topic    string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t setGroupTopicStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		err = clientPointer[userid].SetGroupTopic(group, "", "", t.Topic)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group topic")
+			msg := fmt.Sprintf("Failed to set group topic: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Group Topic set successfully"}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Set group settings, only the fields present in the payload are changed
+func (s *server) SetGroupSettings() http.HandlerFunc {
+
+	type setGroupSettingsStruct struct {
+		GroupJID  string
+		Announce  *bool
+		Locked    *bool
+		Ephemeral *string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t setGroupSettingsStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		if t.Announce == nil && t.Locked == nil && t.Ephemeral == nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Announce, Locked or Ephemeral in payload"))
+			return
+		}
+
+		var timer time.Duration
+		if t.Ephemeral != nil {
+			timer, ok = ephemeralTimers[*t.Ephemeral]
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("ephemeral should be off, 24h, 7d or 90d"))
+				return
+			}
+		}
+
+		if t.Announce != nil {
+			err = clientPointer[userid].SetGroupAnnounce(group, *t.Announce)
+		}
+		if err == nil && t.Locked != nil {
+			err = clientPointer[userid].SetGroupLocked(group, *t.Locked)
+		}
+		if err == nil && t.Ephemeral != nil {
+			err = clientPointer[userid].SetDisappearingTimer(group, timer)
+		}
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group settings")
+			msg := fmt.Sprintf("Failed to set group settings: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Group Settings set successfully"}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Leave group
+func (s *server) LeaveGroup() http.HandlerFunc {
+
+	type leaveGroupStruct struct {
+		GroupJID string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t leaveGroupStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		err = clientPointer[userid].LeaveGroup(group)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to leave group")
+			msg := fmt.Sprintf("Failed to leave group: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Left group successfully"}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Join group with an invite code or link
+func (s *server) JoinGroup() http.HandlerFunc {
+
+	type joinGroupStruct struct {
+		Code string
+	}
 
-		msg := &waProto.Message{
-			ExtendedTextMessage: &waProto.ExtendedTextMessage{
-				Text: &t.Body,
-			},
-		}
+	return func(w http.ResponseWriter, r *http.Request) {
 
-		if t.ContextInfo.StanzaId != nil {
-			msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{
-				StanzaId:      proto.String(*t.ContextInfo.StanzaId),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
-			}
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
 		}
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		decoder := json.NewDecoder(r.Body)
+		var t joinGroupStruct
+		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(
-				w,
-				r,
-				http.StatusInternalServerError,
-				fmt.Errorf("error sending message: %v", err),
-			)
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		log.Info().
-			Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).
-			Str("id", msgid).
-			Msg("Message sent")
-		response := map[string]interface{}{
-			"Details":   "Sent",
-			"Timestamp": resp.Timestamp,
-			"Id":        msgid,
+		if t.Code == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing code in payload"))
+			return
+		}
+
+		group, err := clientPointer[userid].JoinGroupWithLink(t.Code)
+
+		if errors.Is(err, whatsmeow.ErrInviteLinkInvalid) || errors.Is(err, whatsmeow.ErrInviteLinkRevoked) {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		} else if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to join group")
+			msg := fmt.Sprintf("Failed to join group: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
 		}
+
+		response := map[string]interface{}{"Details": "Joined group successfully", "GroupJID": group.String()}
 		responseJson, err := json.Marshal(response)
+
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
@@ -1788,22 +2726,11 @@ func (s *server) SendMessage() http.HandlerFunc {
 	}
 }
 
-// checks if users/phones are on Whatsapp
-func (s *server) CheckUser() http.HandlerFunc {
-
-	type checkUserStruct struct {
-		Phone []string
-	}
-
-	type User struct {
-		Query        string
-		IsInWhatsapp bool
-		JID          string
-		VerifiedName string
-	}
+// Get information about a group from an invite code or link, without joining it
+func (s *server) GetGroupInviteInfo() http.HandlerFunc {
 
-	type UserCollection struct {
-		Users []User
+	type groupInviteInfoStruct struct {
+		Code string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1817,57 +2744,46 @@ func (s *server) CheckUser() http.HandlerFunc {
 		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t checkUserStruct
+		var t groupInviteInfoStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		if len(t.Phone) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+		if t.Code == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing code in payload"))
 			return
 		}
 
-		resp, err := clientPointer[userid].IsOnWhatsApp(t.Phone)
-		if err != nil {
-			s.Respond(
-				w,
-				r,
-				http.StatusInternalServerError,
-				fmt.Errorf("failed to check if users are on whatsapp: %s", err),
-			)
+		response, err := clientPointer[userid].GetGroupInfoFromLink(t.Code)
+
+		if errors.Is(err, whatsmeow.ErrInviteLinkInvalid) || errors.Is(err, whatsmeow.ErrInviteLinkRevoked) {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		} else if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to get group invite info")
+			msg := fmt.Sprintf("Failed to get group invite info: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		uc := new(UserCollection)
-		for _, item := range resp {
-			if item.VerifiedName != nil {
-				var msg = User{Query: item.Query, IsInWhatsapp: item.IsIn, JID: item.JID.String(), VerifiedName: item.VerifiedName.Details.GetVerifiedName()}
-				uc.Users = append(uc.Users, msg)
-			} else {
-				var msg = User{Query: item.Query, IsInWhatsapp: item.IsIn, JID: item.JID.String(), VerifiedName: ""}
-				uc.Users = append(uc.Users, msg)
-			}
-		}
-		responseJson, err := json.Marshal(uc)
+		responseJson, err := json.Marshal(response)
+
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
+
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// Gets user information
-func (s *server) GetUser() http.HandlerFunc {
-
-	type checkUserStruct struct {
-		Phone []string
-	}
+// List pending requests to join a group with admin approval
+func (s *server) GetGroupJoinRequests() http.HandlerFunc {
 
-	type UserCollection struct {
-		Users map[types.JID]types.UserInfo
+	type groupJoinRequestsStruct struct {
+		GroupJID string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1881,57 +2797,47 @@ func (s *server) GetUser() http.HandlerFunc {
 		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t checkUserStruct
+		var t groupJoinRequestsStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		if len(t.Phone) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
 			return
 		}
 
-		var jids []types.JID
-		for _, arg := range t.Phone {
-			jid, ok := parseJID(arg)
-			if !ok {
-				return
-			}
-			jids = append(jids, jid)
-		}
-		resp, err := clientPointer[userid].GetUserInfo(jids)
+		requests, err := getJoinRequests(clientPointer[userid], group)
 
 		if err != nil {
-			msg := fmt.Sprintf("Failed to get user info: %v", err)
-			log.Error().Msg(msg)
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to get group join requests")
+			msg := fmt.Sprintf("Failed to get group join requests: %v", err)
 			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		uc := new(UserCollection)
-		uc.Users = make(map[types.JID]types.UserInfo)
-
-		for jid, info := range resp {
-			uc.Users[jid] = info
-		}
+		response := map[string]interface{}{"Requests": requests}
+		responseJson, err := json.Marshal(response)
 
-		responseJson, err := json.Marshal(uc)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
+
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// Gets avatar info for user
-func (s *server) GetAvatar() http.HandlerFunc {
+// Approve or reject requests to join a group
+func (s *server) UpdateGroupJoinRequests() http.HandlerFunc {
 
-	type getAvatarStruct struct {
-		Phone   string
-		Preview bool
+	type updateJoinRequestsStruct struct {
+		GroupJID     string
+		Action       string
+		Participants []string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1945,59 +2851,62 @@ func (s *server) GetAvatar() http.HandlerFunc {
 		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t getAvatarStruct
+		var t updateJoinRequestsStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		if len(t.Phone) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
 			return
 		}
 
-		jid, ok := parseJID(t.Phone)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+		if !Find(joinRequestActions, t.Action) {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("action should be approve or reject"))
 			return
 		}
 
-		var pic *types.ProfilePictureInfo
+		if len(t.Participants) == 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing participants in payload"))
+			return
+		}
 
-		existingID := ""
-		pic, err = clientPointer[userid].GetProfilePictureInfo(
-			jid,
-			&whatsmeow.GetProfilePictureParams{
-				Preview:    t.Preview,
-				ExistingID: existingID,
-			},
-		)
+		participants, err := parseParticipants(t.Participants)
 		if err != nil {
-			msg := fmt.Sprintf("Failed to get avatar: %v", err)
-			log.Error().Msg(msg)
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
-		if pic == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no avatar found"))
+		results, err := updateJoinRequests(clientPointer[userid], group, t.Action, participants)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to update group join requests")
+			msg := fmt.Sprintf("Failed to update group join requests: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		log.Info().Str("id", pic.ID).Str("url", pic.URL).Msg("Got avatar")
+		response := map[string]interface{}{"Participants": results}
+		responseJson, err := json.Marshal(response)
 
-		responseJson, err := json.Marshal(pic)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
+
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// Gets all contacts
-func (s *server) GetContacts() http.HandlerFunc {
+// List subscribed communities with their announcement and linked groups
+func (s *server) ListCommunities() http.HandlerFunc {
+
+	type CommunityCollection struct {
+		Communities []communityInfo
+	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -2009,13 +2918,32 @@ func (s *server) GetContacts() http.HandlerFunc {
 			return
 		}
 
-		result, err := clientPointer[userid].Store.Contacts.GetAllContacts()
+		resp, err := clientPointer[userid].GetJoinedGroups()
+
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			msg := fmt.Sprintf("Failed to get group list: %v", err)
+			log.Error().Msg(msg)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		responseJson, err := json.Marshal(result)
+		response := &CommunityCollection{Communities: []communityInfo{}}
+		for _, info := range resp {
+			if !info.IsParent {
+				continue
+			}
+			community, err := getCommunity(clientPointer[userid], info)
+			if err != nil {
+				msg := fmt.Sprintf("Failed to get community groups: %v", err)
+				log.Error().Msg(msg)
+				s.Respond(w, r, http.StatusInternalServerError, msg)
+				return
+			}
+			response.Communities = append(response.Communities, *community)
+		}
+
+		responseJson, err := json.Marshal(response)
+
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
@@ -2025,13 +2953,12 @@ func (s *server) GetContacts() http.HandlerFunc {
 	}
 }
 
-// Sets Chat Presence (typing/paused/recording audio)
-func (s *server) ChatPresence() http.HandlerFunc {
+// Create community, the announcement group is created by WhatsApp
+func (s *server) CreateCommunity() http.HandlerFunc {
 
-	type chatPresenceStruct struct {
-		Phone string
-		State string
-		Media types.ChatPresenceMedia
+	type createCommunityStruct struct {
+		Name         string
+		Participants []string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -2045,46 +2972,37 @@ func (s *server) ChatPresence() http.HandlerFunc {
 		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t chatPresenceStruct
+		var t createCommunityStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		if len(t.Phone) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+		if t.Name == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing name in payload"))
 			return
 		}
 
-		if len(t.State) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing state in payload"))
+		participants, err := parseParticipants(t.Participants)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
-		jid, ok := parseJID(t.Phone)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
-			return
-		}
+		req := whatsmeow.ReqCreateGroup{Name: t.Name, Participants: participants}
+		req.IsParent = true
+		response, err := clientPointer[userid].CreateGroup(req)
 
-		err = clientPointer[userid].SendChatPresence(
-			jid,
-			types.ChatPresence(t.State),
-			types.ChatPresenceMedia(t.Media),
-		)
 		if err != nil {
-			s.Respond(
-				w,
-				r,
-				http.StatusInternalServerError,
-				errors.New("failure sending chat presence to whatsapp servers"),
-			)
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to create community")
+			msg := fmt.Sprintf("Failed to create community: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		response := map[string]interface{}{"Details": "Chat presence set successfuly"}
 		responseJson, err := json.Marshal(response)
+
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
@@ -2094,17 +3012,12 @@ func (s *server) ChatPresence() http.HandlerFunc {
 	}
 }
 
-// Downloads Image and returns base64 representation
-func (s *server) DownloadImage() http.HandlerFunc {
+// Link an existing group to a community
+func (s *server) LinkCommunityGroup() http.HandlerFunc {
 
-	type downloadImageStruct struct {
-		Url           string
-		DirectPath    string
-		MediaKey      []byte
-		Mimetype      string
-		FileEncSHA256 []byte
-		FileSHA256    []byte
-		FileLength    uint64
+	type communityGroupStruct struct {
+		CommunityJID string
+		GroupJID     string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -2112,83 +3025,58 @@ func (s *server) DownloadImage() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		userid, _ := strconv.Atoi(txtid)
 
-		mimetype := ""
-		var imgdata []byte
-
 		if clientPointer[userid] == nil {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
 			return
 		}
 
-		// check/creates user directory for files
-		userDirectory := fmt.Sprintf("%s/files/user_%s", s.exPath, txtid)
-		_, err := os.Stat(userDirectory)
-		if os.IsNotExist(err) {
-			errDir := os.MkdirAll(userDirectory, 0751)
-			if errDir != nil {
-				s.Respond(
-					w,
-					r,
-					http.StatusInternalServerError,
-					fmt.Errorf("could not create user directory (%s)", userDirectory),
-				)
-				return
-			}
-		}
-
 		decoder := json.NewDecoder(r.Body)
-		var t downloadImageStruct
-		err = decoder.Decode(&t)
+		var t communityGroupStruct
+		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		msg := &waProto.Message{ImageMessage: &waProto.ImageMessage{
-			Url:           proto.String(t.Url),
-			DirectPath:    proto.String(t.DirectPath),
-			MediaKey:      t.MediaKey,
-			Mimetype:      proto.String(t.Mimetype),
-			FileEncSha256: t.FileEncSHA256,
-			FileSha256:    t.FileSHA256,
-			FileLength:    &t.FileLength,
-		}}
+		community, ok := parseJID(t.CommunityJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse community jid"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
 
-		img := msg.GetImageMessage()
+		err = clientPointer[userid].LinkGroup(community, group)
 
-		if img != nil {
-			imgdata, err = clientPointer[userid].Download(img)
-			if err != nil {
-				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to download image")
-				msg := fmt.Sprintf("Failed to download image %v", err)
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
-				return
-			}
-			mimetype = img.GetMimetype()
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to link group")
+			msg := fmt.Sprintf("Failed to link group: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
 		}
 
-		dataURL := dataurl.New(imgdata, mimetype)
-		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
+		response := map[string]interface{}{"Details": "Group linked successfully"}
 		responseJson, err := json.Marshal(response)
+
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
+
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// Downloads Document and returns base64 representation
-func (s *server) DownloadDocument() http.HandlerFunc {
+// Unlink a group from a community
+func (s *server) UnlinkCommunityGroup() http.HandlerFunc {
 
-	type downloadDocumentStruct struct {
-		Url           string
-		DirectPath    string
-		MediaKey      []byte
-		Mimetype      string
-		FileEncSHA256 []byte
-		FileSHA256    []byte
-		FileLength    uint64
+	type communityGroupStruct struct {
+		CommunityJID string
+		GroupJID     string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -2196,64 +3084,43 @@ func (s *server) DownloadDocument() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		userid, _ := strconv.Atoi(txtid)
 
-		mimetype := ""
-		var docdata []byte
-
 		if clientPointer[userid] == nil {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
 			return
 		}
 
-		// check/creates user directory for files
-		userDirectory := fmt.Sprintf("%s/files/user_%s", s.exPath, txtid)
-		_, err := os.Stat(userDirectory)
-		if os.IsNotExist(err) {
-			errDir := os.MkdirAll(userDirectory, 0751)
-			if errDir != nil {
-				s.Respond(
-					w,
-					r,
-					http.StatusInternalServerError,
-					fmt.Errorf("could not create user directory (%s)", userDirectory),
-				)
-				return
-			}
-		}
-
 		decoder := json.NewDecoder(r.Body)
-		var t downloadDocumentStruct
-		err = decoder.Decode(&t)
+		var t communityGroupStruct
+		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		msg := &waProto.Message{DocumentMessage: &waProto.DocumentMessage{
-			Url:           proto.String(t.Url),
-			DirectPath:    proto.String(t.DirectPath),
-			MediaKey:      t.MediaKey,
-			Mimetype:      proto.String(t.Mimetype),
-			FileEncSha256: t.FileEncSHA256,
-			FileSha256:    t.FileSHA256,
-			FileLength:    &t.FileLength,
-		}}
+		community, ok := parseJID(t.CommunityJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse community jid"))
+			return
+		}
 
-		doc := msg.GetDocumentMessage()
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
 
-		if doc != nil {
-			docdata, err = clientPointer[userid].Download(doc)
-			if err != nil {
-				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to download document")
-				msg := fmt.Sprintf("Failed to download document %v", err)
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
-				return
-			}
-			mimetype = doc.GetMimetype()
+		err = clientPointer[userid].UnlinkGroup(community, group)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to unlink group")
+			msg := fmt.Sprintf("Failed to unlink group: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
 		}
 
-		dataURL := dataurl.New(docdata, mimetype)
-		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
+		response := map[string]interface{}{"Details": "Group unlinked successfully"}
 		responseJson, err := json.Marshal(response)
+
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
@@ -2263,739 +3130,1047 @@ func (s *server) DownloadDocument() http.HandlerFunc {
 	}
 }
 
-// Downloads Video and returns base64 representation
-func (s *server) DownloadVideo() http.HandlerFunc {
+// Middleware: Authenticate admin connections based on Authorization header
+func (s *server) authadmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		if *adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(*adminToken)) != 1 {
+			s.Respond(w, r, http.StatusUnauthorized, errors.New("Unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-	type downloadVideoStruct struct {
-		Url           string
-		DirectPath    string
-		MediaKey      []byte
-		Mimetype      string
-		FileEncSHA256 []byte
-		FileSHA256    []byte
-		FileLength    uint64
+type adminUser struct {
+	Id         int    `json:"id"`
+	Name       string `json:"name"`
+	Token      string `json:"token"`
+	Webhook    string `json:"webhook"`
+	Jid        string `json:"jid"`
+	Connected  bool   `json:"connected"`
+	Expiration int64  `json:"expiration"`
+	Events     string `json:"events"`
+}
+
+// Reads a single user row, returns sql.ErrNoRows if it does not exist
+func (s *server) getAdminUser(id int) (adminUser, error) {
+	var u adminUser
+	var connected, expiration sql.NullInt64
+	row := s.db.QueryRow("SELECT id,name,token,webhook,jid,connected,expiration,events FROM users WHERE id=? LIMIT 1", id)
+	err := row.Scan(&u.Id, &u.Name, &u.Token, &u.Webhook, &u.Jid, &connected, &expiration, &u.Events)
+	if err != nil {
+		return u, err
 	}
+	u.Connected = connected.Int64 == 1
+	u.Expiration = expiration.Int64
+	return u, nil
+}
 
-	return func(w http.ResponseWriter, r *http.Request) {
+// Disconnects a user session (if any) and drops its cached information
+func (s *server) dropUserSession(id int, token string) {
+	if client := clientPointer[id]; client != nil {
+		select {
+		case killchannel[id] <- true:
+		default:
+			// The client is not in its main loop yet, e.g. while waiting for QR pairing
+			client.Disconnect()
+			delete(clientPointer, id)
+			delete(myClientPointer, id)
+		}
+	}
+	userinfocache.Delete(token)
+}
 
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-		userid, _ := strconv.Atoi(txtid)
+// Reloads the cached information of a user and updates its running session, if any
+func (s *server) refreshUserSession(id int, oldToken string) error {
+	var txtid, token, webhook, jid, events, webhookFormat, webhookSecret, webhookMedia, webhookSchema, webhookRaw, mediaDownload string
+	err := s.db.QueryRow(
+		"SELECT id,token,webhook,jid,events,webhook_format,webhook_secret,webhook_media,webhook_schema,webhook_raw,media_download FROM users WHERE id=? LIMIT 1",
+		id,
+	).Scan(&txtid, &token, &webhook, &jid, &events, &webhookFormat, &webhookSecret, &webhookMedia, &webhookSchema, &webhookRaw, &mediaDownload)
+	if err != nil {
+		return err
+	}
+	v := Values{map[string]string{
+		"Id":            txtid,
+		"Jid":           jid,
+		"Webhook":       webhook,
+		"Token":         token,
+		"Events":        events,
+		"WebhookFormat": webhookFormat,
+		"WebhookSecret": webhookSecret,
+		"WebhookMedia":  webhookMedia,
+		"WebhookSchema": webhookSchema,
+		"WebhookRaw":    webhookRaw,
+		"MediaDownload": mediaDownload,
+	}}
+	userinfocache.Delete(oldToken)
+	userinfocache.Set(token, v, cache.NoExpiration)
+
+	if mycli := myClientPointer[id]; mycli != nil {
+		mycli.setSession(token, parseSubscriptions(strings.Split(events, ",")))
+	}
+	return nil
+}
 
-		mimetype := ""
-		var docdata []byte
+// List users
+func (s *server) ListUsers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		rows, err := s.db.Query("SELECT id FROM users ORDER BY id")
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not list users: %v", err))
 			return
 		}
+		var ids []int
+		for rows.Next() {
+			var id int
+			if err = rows.Scan(&id); err != nil {
+				rows.Close()
+				s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not list users: %v", err))
+				return
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
 
-		// check/creates user directory for files
-		userDirectory := fmt.Sprintf("%s/files/user_%s", s.exPath, txtid)
-		_, err := os.Stat(userDirectory)
-		if os.IsNotExist(err) {
-			errDir := os.MkdirAll(userDirectory, 0751)
-			if errDir != nil {
-				s.Respond(
-					w,
-					r,
-					http.StatusInternalServerError,
-					fmt.Errorf("could not create user directory (%s)", userDirectory),
-				)
+		users := []adminUser{}
+		for _, id := range ids {
+			u, err := s.getAdminUser(id)
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not list users: %v", err))
 				return
 			}
+			users = append(users, u)
 		}
 
-		decoder := json.NewDecoder(r.Body)
-		var t downloadVideoStruct
-		err = decoder.Decode(&t)
+		response := map[string]interface{}{"Users": users}
+		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
 
-		msg := &waProto.Message{VideoMessage: &waProto.VideoMessage{
-			Url:           proto.String(t.Url),
-			DirectPath:    proto.String(t.DirectPath),
-			MediaKey:      t.MediaKey,
-			Mimetype:      proto.String(t.Mimetype),
-			FileEncSha256: t.FileEncSHA256,
-			FileSha256:    t.FileSHA256,
-			FileLength:    &t.FileLength,
-		}}
+// Get user
+func (s *server) GetAdminUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 
-		doc := msg.GetVideoMessage()
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid user id"))
+			return
+		}
 
-		if doc != nil {
-			docdata, err = clientPointer[userid].Download(doc)
-			if err != nil {
-				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to download video")
-				msg := fmt.Sprintf("Failed to download video %v", err)
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
-				return
-			}
-			mimetype = doc.GetMimetype()
+		u, err := s.getAdminUser(id)
+		if err == sql.ErrNoRows {
+			s.Respond(w, r, http.StatusNotFound, errors.New("user not found"))
+			return
+		} else if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get user: %v", err))
+			return
 		}
 
-		dataURL := dataurl.New(docdata, mimetype)
-		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
-		responseJson, err := json.Marshal(response)
+		responseJson, err := json.Marshal(u)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
-
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// Downloads Audio and returns base64 representation
-func (s *server) DownloadAudio() http.HandlerFunc {
+// Create a User
+func (s *server) CreateUser() http.HandlerFunc {
 
-	type downloadAudioStruct struct {
-		Url           string
-		DirectPath    string
-		MediaKey      []byte
-		Mimetype      string
-		FileEncSHA256 []byte
-		FileSHA256    []byte
-		FileLength    uint64
+	type createUserStruct struct {
+		Name       string
+		Token      string
+		Webhook    string
+		Events     string
+		Expiration int64
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-		userid, _ := strconv.Atoi(txtid)
-
-		mimetype := ""
-		var docdata []byte
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		decoder := json.NewDecoder(r.Body)
+		var t createUserStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		// check/creates user directory for files
-		userDirectory := fmt.Sprintf("%s/files/user_%s", s.exPath, txtid)
-		_, err := os.Stat(userDirectory)
-		if os.IsNotExist(err) {
-			errDir := os.MkdirAll(userDirectory, 0751)
-			if errDir != nil {
-				s.Respond(
-					w,
-					r,
-					http.StatusInternalServerError,
-					fmt.Errorf("could not create user directory (%s)", userDirectory),
-				)
-				return
-			}
+		if t.Name == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing name in payload"))
+			return
 		}
 
-		decoder := json.NewDecoder(r.Body)
-		var t downloadAudioStruct
-		err = decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+		if t.Token == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing token in payload"))
 			return
 		}
 
-		msg := &waProto.Message{AudioMessage: &waProto.AudioMessage{
-			Url:           proto.String(t.Url),
-			DirectPath:    proto.String(t.DirectPath),
-			MediaKey:      t.MediaKey,
-			Mimetype:      proto.String(t.Mimetype),
-			FileEncSha256: t.FileEncSHA256,
-			FileSha256:    t.FileSHA256,
-			FileLength:    &t.FileLength,
-		}}
+		if t.Events == "" {
+			t.Events = "All"
+		}
 
-		doc := msg.GetAudioMessage()
+		// Check if user already exists
+		var existing int
+		err = s.db.QueryRow("SELECT id FROM users WHERE token=? LIMIT 1", t.Token).Scan(&existing)
+		if err == nil {
+			s.Respond(w, r, http.StatusConflict, errors.New("user already exists"))
+			return
+		} else if err != sql.ErrNoRows {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Error querying DB")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("error querying db"))
+			return
+		}
 
-		if doc != nil {
-			docdata, err = clientPointer[userid].Download(doc)
-			if err != nil {
-				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to download audio")
-				msg := fmt.Sprintf("Failed to download audio %v", err)
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
-				return
-			}
-			mimetype = doc.GetMimetype()
+		res, err := s.db.Exec(
+			"INSERT INTO users(name,token,webhook,events,expiration) VALUES(?,?,?,?,?)",
+			t.Name, t.Token, t.Webhook, t.Events, t.Expiration,
+		)
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Error executing DB statement")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("error executing db statement"))
+			return
 		}
 
-		dataURL := dataurl.New(docdata, mimetype)
-		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
-		responseJson, err := json.Marshal(response)
+		id, err := res.LastInsertId()
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Error getting last insert id")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("error getting last insert id"))
 			return
 		}
 
-		s.Respond(w, r, http.StatusOK, string(responseJson))
+		u, err := s.getAdminUser(int(id))
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get user: %v", err))
+			return
+		}
+
+		log.Info().Int64("userid", id).Str("name", t.Name).Msg("User created")
+		responseJson, err := json.Marshal(u)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		s.Respond(w, r, http.StatusCreated, string(responseJson))
 	}
 }
 
-// React
-func (s *server) React() http.HandlerFunc {
+// Update a User, only fields present in the payload are changed
+func (s *server) UpdateUser() http.HandlerFunc {
 
-	type textStruct struct {
-		Phone string
-		Body  string
-		Id    string
+	type updateUserStruct struct {
+		Name       *string
+		Token      *string
+		Webhook    *string
+		Events     *string
+		Expiration *int64
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-		userid, _ := strconv.Atoi(txtid)
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
-			return
-		}
-
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
-		decoder := json.NewDecoder(r.Body)
-		var t textStruct
-		err := decoder.Decode(&t)
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid user id"))
 			return
 		}
 
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+		u, err := s.getAdminUser(id)
+		if err == sql.ErrNoRows {
+			s.Respond(w, r, http.StatusNotFound, errors.New("user not found"))
 			return
-		}
-
-		if t.Body == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing body in payload"))
+		} else if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get user: %v", err))
 			return
 		}
+		oldToken := u.Token
 
-		recipient, ok := parseJID(t.Phone)
-		if !ok {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+		decoder := json.NewDecoder(r.Body)
+		var t updateUserStruct
+		err = decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		if t.Id == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing id in payload"))
-			return
-		} else {
-			msgid = t.Id
+		if t.Name != nil {
+			if *t.Name == "" {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("name cannot be empty"))
+				return
+			}
+			u.Name = *t.Name
 		}
-
-		fromMe := false
-		if strings.HasPrefix(msgid, "me:") {
-			fromMe = true
-			msgid = msgid[len("me:"):]
+		if t.Token != nil {
+			if *t.Token == "" {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("token cannot be empty"))
+				return
+			}
+			var existing int
+			err = s.db.QueryRow("SELECT id FROM users WHERE token=? AND id<>? LIMIT 1", *t.Token, id).Scan(&existing)
+			if err == nil {
+				s.Respond(w, r, http.StatusConflict, errors.New("token already in use"))
+				return
+			} else if err != sql.ErrNoRows {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New("error querying db"))
+				return
+			}
+			u.Token = *t.Token
 		}
-		reaction := t.Body
-		if reaction == "remove" {
-			reaction = ""
+		if t.Webhook != nil {
+			u.Webhook = *t.Webhook
 		}
-
-		msg := &waProto.Message{
-			ReactionMessage: &waProto.ReactionMessage{
-				Key: &waProto.MessageKey{
-					RemoteJid: proto.String(recipient.String()),
-					FromMe:    proto.Bool(fromMe),
-					Id:        proto.String(msgid),
-				},
-				Text:              proto.String(reaction),
-				GroupingKey:       proto.String(reaction),
-				SenderTimestampMs: proto.Int64(time.Now().UnixMilli()),
-			},
+		if t.Events != nil {
+			u.Events = *t.Events
+		}
+		if t.Expiration != nil {
+			u.Expiration = *t.Expiration
 		}
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		_, err = s.db.Exec(
+			"UPDATE users SET name=?,token=?,webhook=?,events=?,expiration=? WHERE id=?",
+			u.Name, u.Token, u.Webhook, u.Events, u.Expiration, id,
+		)
 		if err != nil {
-			s.Respond(
-				w,
-				r,
-				http.StatusInternalServerError,
-				fmt.Errorf("error sending message: %v", err),
-			)
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not update user: %v", err))
 			return
 		}
 
-		log.Info().
-			Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).
-			Str("id", msgid).
-			Msg("Message sent")
-		response := map[string]interface{}{
-			"Details":   "Sent",
-			"Timestamp": resp.Timestamp,
-			"Id":        msgid,
+		// Cached user info is keyed by token, the running session keeps using it for webhooks
+		err = s.refreshUserSession(id, oldToken)
+		if err != nil {
+			log.Error().Err(err).Int("userid", id).Msg("Could not refresh user session")
+			userinfocache.Delete(oldToken)
 		}
-		responseJson, err := json.Marshal(response)
+
+		log.Info().Int("userid", id).Msg("User updated")
+		responseJson, err := json.Marshal(u)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
-
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// Mark messages as read
-func (s *server) MarkRead() http.HandlerFunc {
+// Tables with rows owned by a user, besides the users table itself
+var userDataTables = []string{"webhooks", "outbox", "messages", "chats", "poll_votes", "webhook_queue", "webhook_deadletter"}
 
-	type markReadStruct struct {
-		Id     []string
-		Chat   types.JID
-		Sender types.JID
+// Deletes a user and all its stored data
+func deleteUserData(db *sql.DB, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range userDataTables {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE user_id=?", id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM users WHERE id=?", id)
+	if err != nil {
+		return err
 	}
+	return tx.Commit()
+}
 
+// Delete a User, logging out its device and removing its messages and media
+func (s *server) DeleteUser() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-		userid, _ := strconv.Atoi(txtid)
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid user id"))
 			return
 		}
 
-		decoder := json.NewDecoder(r.Body)
-		var t markReadStruct
-		err := decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+		u, err := s.getAdminUser(id)
+		if err == sql.ErrNoRows {
+			s.Respond(w, r, http.StatusNotFound, errors.New("user not found"))
+			return
+		} else if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get user: %v", err))
 			return
 		}
 
-		if t.Chat.String() == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing chat in payload"))
-			return
+		// Unpair the device so it does not stay linked to the phone
+		if client := clientPointer[id]; client != nil && client.IsLoggedIn() && client.IsConnected() {
+			err = client.Logout()
+			if err != nil {
+				log.Warn().Err(err).Int("userid", id).Msg("Could not log out device")
+			}
+		}
+		s.dropUserSession(id, u.Token)
+		if jid, ok := parseJID(u.Jid); ok {
+			device, err := container.GetDevice(jid)
+			if err != nil {
+				log.Warn().Err(err).Int("userid", id).Msg("Could not get device")
+			} else if device != nil {
+				err = device.Delete()
+				if err != nil {
+					log.Warn().Err(err).Int("userid", id).Msg("Could not delete device")
+				}
+			}
 		}
 
-		if len(t.Id) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing id in payload"))
+		err = deleteUserData(s.db, id)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not delete user: %v", err))
 			return
 		}
+		webhookcache.Delete(strconv.Itoa(id))
 
-		err = clientPointer[userid].MarkRead(t.Id, time.Now(), t.Chat, t.Sender)
+		err = removeAllUserFiles(id)
 		if err != nil {
-			s.Respond(
-				w,
-				r,
-				http.StatusInternalServerError,
-				errors.New("failure marking messages as read"),
-			)
-			return
+			log.Warn().Err(err).Int("userid", id).Msg("Could not delete media files")
 		}
 
-		response := map[string]interface{}{"Details": "Message(s) marked as read"}
+		log.Info().Int("userid", id).Msg("User deleted")
+		response := map[string]interface{}{"Details": "User deleted", "Id": id}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
-
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// List groups
-func (s *server) ListGroups() http.HandlerFunc {
+// Gets storage used by saved media files for all users
+func (s *server) ListMediaUsage() http.HandlerFunc {
 
-	type GroupCollection struct {
-		Groups []types.GroupInfo
+	type userUsage struct {
+		Id         int
+		Name       string
+		Bytes      int64
+		Files      int
+		ByType     map[string]int64
+		QuotaBytes int64
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-		userid, _ := strconv.Atoi(txtid)
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		rows, err := s.db.Query("SELECT id,name,media_quota FROM users ORDER BY id")
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not list users: %v", err))
 			return
 		}
+		users := []userUsage{}
+		for rows.Next() {
+			var u userUsage
+			if err = rows.Scan(&u.Id, &u.Name, &u.QuotaBytes); err != nil {
+				rows.Close()
+				s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not list users: %v", err))
+				return
+			}
+			users = append(users, u)
+		}
+		rows.Close()
 
-		resp, err := clientPointer[userid].GetJoinedGroups()
+		for i := range users {
+			usage, err := getMediaUsage(users[i].Id)
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get media usage: %v", err))
+				return
+			}
+			users[i].Bytes = usage.Bytes
+			users[i].Files = usage.Files
+			users[i].ByType = usage.ByType
+		}
 
+		response := map[string]interface{}{"Users": users}
+		responseJson, err := json.Marshal(response)
 		if err != nil {
-			msg := fmt.Sprintf("Failed to get group list: %v", err)
-			log.Error().Msg(msg)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
 
-		gc := new(GroupCollection)
-		for _, info := range resp {
-			gc.Groups = append(gc.Groups, *info)
-		}
+// Sends a message (or queues it for delivery) and writes the API response
+func (s *server) sendAndRespond(w http.ResponseWriter, r *http.Request, userid int, recipient types.JID, msgid string, msg *waProto.Message, queue bool) {
 
-		responseJson, err := json.Marshal(gc)
+	if queue {
+		err := s.enqueueMessage(userid, recipient, msgid, msg)
+		if err == errMessageExists {
+			s.Respond(w, r, http.StatusConflict, err)
+			return
+		} else if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		log.Info().Str("id", msgid).Msg("Message queued")
+		response := map[string]interface{}{
+			"Details": "Queued",
+			"Id":      msgid,
+		}
+		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
+		s.Respond(w, r, http.StatusAccepted, string(responseJson))
+		return
+	}
 
-		s.Respond(w, r, http.StatusOK, string(responseJson))
+	resp, err := clientPointer[userid].SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+	if err != nil {
+		s.Respond(
+			w,
+			r,
+			http.StatusInternalServerError,
+			fmt.Errorf("error sending message: %v", err),
+		)
+		return
+	}
+	s.recordSentMessage(userid, recipient, msgid)
+	storeSentMessage(s.db, userid, clientPointer[userid], recipient, msgid, msg, resp.Timestamp)
+
+	log.Info().
+		Str("timestamp", fmt.Sprintf("%d", resp.Timestamp.Unix())).
+		Str("id", msgid).
+		Msg("Message sent")
+	response := map[string]interface{}{
+		"Details":   "Sent",
+		"Timestamp": resp.Timestamp,
+		"Id":        msgid,
+	}
+	responseJson, err := json.Marshal(response)
+	if err != nil {
+		s.Respond(w, r, http.StatusInternalServerError, err)
+		return
 	}
+	s.Respond(w, r, http.StatusOK, string(responseJson))
 }
 
-// Get group info
-func (s *server) GetGroupInfo() http.HandlerFunc {
+// Gets delivery status for a sent or queued message
+func (s *server) GetMessageStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 
-	type getGroupInfoStruct struct {
-		GroupJID string
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+		msgid := mux.Vars(r)["id"]
+
+		var recipient, status, lastError string
+		var attempts int
+		var createdAt, updatedAt int64
+		err := s.db.QueryRow(
+			"SELECT recipient,status,attempts,last_error,created_at,updated_at FROM outbox WHERE user_id=? AND id=? LIMIT 1",
+			userid, msgid,
+		).Scan(&recipient, &status, &attempts, &lastError, &createdAt, &updatedAt)
+		if err == sql.ErrNoRows {
+			s.Respond(w, r, http.StatusNotFound, errors.New("message not found"))
+			return
+		} else if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get message status: %v", err))
+			return
+		}
+
+		response := map[string]interface{}{
+			"Id":        msgid,
+			"Recipient": recipient,
+			"Status":    status,
+			"Attempts":  attempts,
+			"Error":     lastError,
+			"CreatedAt": time.Unix(createdAt, 0),
+			"UpdatedAt": time.Unix(updatedAt, 0),
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
+}
 
+// Gets the current results of a poll
+func (s *server) GetPoll() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		userid, _ := strconv.Atoi(txtid)
 
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		tally, err := getPollTally(s.db, userid, mux.Vars(r)["id"])
+		if err == sql.ErrNoRows {
+			s.Respond(w, r, http.StatusNotFound, errors.New("poll not found"))
+			return
+		} else if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get poll: %v", err))
 			return
 		}
 
-		decoder := json.NewDecoder(r.Body)
-		var t getGroupInfoStruct
-		err := decoder.Decode(&t)
+		responseJson, err := json.Marshal(tally)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Gets stored messages, filtered by chat, sender, time range and text search
+func (s *server) GetHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+		query := r.URL.Query()
+
+		sqlStmt := "SELECT m.id,m.chat,m.sender,m.from_me,m.timestamp,m.type,m.text,m.media,m.quoted_id FROM messages m"
+		where := []string{"m.user_id=?"}
+		args := []interface{}{userid}
+
+		if search := searchQuery(query.Get("q")); search != "" {
+			sqlStmt += " JOIN messages_fts f ON f.rowid=m.seq"
+			where = append(where, "messages_fts MATCH ?")
+			args = append(args, search)
+		}
+		if chat := query.Get("chat"); chat != "" {
+			jid, ok := parseChatJID(chat)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse chat"))
+				return
+			}
+			where = append(where, "m.chat=?")
+			args = append(args, jid.String())
+		}
+		if sender := query.Get("sender"); sender != "" {
+			jid, ok := parseChatJID(sender)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse sender"))
+				return
+			}
+			where = append(where, "m.sender=?")
+			args = append(args, jid.String())
+		}
+		for _, param := range []string{"from", "to"} {
+			value := query.Get(param)
+			if value == "" {
+				continue
+			}
+			ts, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, fmt.Errorf("%s should be a unix timestamp", param))
+				return
+			}
+			if param == "from" {
+				where = append(where, "m.timestamp>=?")
+			} else {
+				where = append(where, "m.timestamp<=?")
+			}
+			args = append(args, ts)
+		}
 
-		group, ok := parseJID(t.GroupJID)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
-			return
+		limit := 50
+		if value := query.Get("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 500 {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("limit should be between 1 and 500"))
+				return
+			}
+			limit = n
+		}
+		offset := 0
+		if value := query.Get("offset"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("invalid offset"))
+				return
+			}
+			offset = n
 		}
 
-		resp, err := clientPointer[userid].GetGroupInfo(group)
+		sqlStmt += " WHERE " + strings.Join(where, " AND ") + " ORDER BY m.timestamp DESC, m.seq DESC LIMIT ? OFFSET ?"
+		args = append(args, limit, offset)
 
+		rows, err := s.db.Query(sqlStmt, args...)
 		if err != nil {
-			msg := fmt.Sprintf("Failed to get group info: %v", err)
-			log.Error().Msg(msg)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get history: %v", err))
 			return
 		}
+		defer rows.Close()
 
-		responseJson, err := json.Marshal(resp)
+		messages := []storedMessage{}
+		for rows.Next() {
+			var m storedMessage
+			var timestamp int64
+			err = rows.Scan(&m.Id, &m.Chat, &m.Sender, &m.FromMe, &timestamp, &m.Type, &m.Text, &m.Media, &m.QuotedId)
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get history: %v", err))
+				return
+			}
+			m.Timestamp = time.Unix(timestamp, 0)
+			messages = append(messages, m)
+		}
 
+		response := map[string]interface{}{"Messages": messages}
+		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
-
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// Get group invite link
-func (s *server) GetGroupInviteLink() http.HandlerFunc {
-
-	type getGroupInfoStruct struct {
-		GroupJID string
-		Reset    bool
-	}
-
+// Lists conversations with their last message, most recent first and pinned chats on top
+func (s *server) ListChats() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		userid, _ := strconv.Atoi(txtid)
+		query := r.URL.Query()
 
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
-			return
+		where := "c.user_id=?"
+		args := []interface{}{userid}
+		if archived := query.Get("archived"); archived != "" {
+			value, err := strconv.ParseBool(archived)
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("archived should be true or false"))
+				return
+			}
+			where += " AND c.archived=?"
+			args = append(args, value)
 		}
 
-		decoder := json.NewDecoder(r.Body)
-		var t getGroupInfoStruct
-		err := decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
-			return
+		limit := 50
+		if value := query.Get("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 500 {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("limit should be between 1 and 500"))
+				return
+			}
+			limit = n
 		}
-
-		group, ok := parseJID(t.GroupJID)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
-			return
+		offset := 0
+		if value := query.Get("offset"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("invalid offset"))
+				return
+			}
+			offset = n
 		}
+		args = append(args, limit, offset)
 
-		resp, err := clientPointer[userid].GetGroupInviteLink(group, t.Reset)
-
+		rows, err := s.db.Query(
+			`SELECT c.jid,c.name,c.unread,c.archived,c.pinned,c.muted_until,c.last_activity,
+			m.id,m.sender,m.from_me,m.timestamp,m.type,m.text,m.media,m.quoted_id
+			FROM chats c LEFT JOIN messages m ON m.seq=(SELECT seq FROM messages WHERE user_id=c.user_id AND chat=c.jid ORDER BY timestamp DESC, seq DESC LIMIT 1)
+			WHERE `+where+` ORDER BY c.pinned DESC, c.last_activity DESC LIMIT ? OFFSET ?`,
+			args...,
+		)
 		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to get group invite link")
-			msg := fmt.Sprintf("Failed to get group invite link: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not list chats: %v", err))
 			return
 		}
+		defer rows.Close()
 
-		response := map[string]interface{}{"InviteLink": resp}
-		responseJson, err := json.Marshal(response)
+		chats := []chatSummary{}
+		for rows.Next() {
+			var c chatSummary
+			var mutedUntil, lastActivity int64
+			var msgId, msgSender, msgType, msgText, msgMedia, msgQuoted sql.NullString
+			var msgFromMe sql.NullBool
+			var msgTimestamp sql.NullInt64
+			err = rows.Scan(&c.Jid, &c.Name, &c.Unread, &c.Archived, &c.Pinned, &mutedUntil, &lastActivity,
+				&msgId, &msgSender, &msgFromMe, &msgTimestamp, &msgType, &msgText, &msgMedia, &msgQuoted)
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not list chats: %v", err))
+				return
+			}
+			c.LastActivity = time.Unix(lastActivity, 0)
+			if mutedUntil == mutedForever {
+				c.Muted = true
+			} else if mutedUntil > time.Now().Unix() {
+				c.Muted = true
+				until := time.Unix(mutedUntil, 0)
+				c.MutedUntil = &until
+			}
+			if msgId.Valid {
+				c.LastMessage = &storedMessage{
+					Id:        msgId.String,
+					Chat:      c.Jid,
+					Sender:    msgSender.String,
+					FromMe:    msgFromMe.Bool,
+					Timestamp: time.Unix(msgTimestamp.Int64, 0),
+					Type:      msgType.String,
+					Text:      msgText.String,
+					Media:     msgMedia.String,
+					QuotedId:  msgQuoted.String,
+				}
+			}
+			if c.Name == "" && clientPointer[userid] != nil {
+				if jid, err := types.ParseJID(c.Jid); err == nil && jid.Server == types.DefaultUserServer {
+					if contact, err := clientPointer[userid].Store.Contacts.GetContact(jid); err == nil {
+						c.Name = contact.FullName
+						if c.Name == "" {
+							c.Name = contact.PushName
+						}
+					}
+				}
+			}
+			chats = append(chats, c)
+		}
 
+		response := map[string]interface{}{"Chats": chats}
+		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
-
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// Set group photo
-func (s *server) SetGroupPhoto() http.HandlerFunc {
-
-	type setGroupPhotoStruct struct {
-		GroupJID string
-		Image    string
-	}
-
+// Streams a stored media file, with support for range requests
+func (s *server) GetMedia() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-		userid, _ := strconv.Atoi(txtid)
+		msgid := mux.Vars(r)["id"]
 
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		var media string
+		err := s.db.QueryRow(
+			"SELECT media FROM messages WHERE user_id=? AND id=? AND media<>'' ORDER BY timestamp DESC LIMIT 1",
+			txtid, msgid,
+		).Scan(&media)
+		if err == sql.ErrNoRows {
+			s.Respond(w, r, http.StatusNotFound, errors.New("media not found"))
 			return
-		}
-
-		decoder := json.NewDecoder(r.Body)
-		var t setGroupPhotoStruct
-		err := decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+		} else if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get media: %v", err))
 			return
 		}
 
-		group, ok := parseJID(t.GroupJID)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+		file, info, err := mediaStore.Open(media)
+		if err == errMediaNotFound {
+			s.Respond(w, r, http.StatusNotFound, errors.New("media file not found"))
 			return
-		}
-
-		if t.Image == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing image in payload"))
+		} else if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not open media: %v", err))
 			return
 		}
+		defer file.Close()
 
-		var filedata []byte
-
-		if t.Image[0:13] == "data:image/jp" {
-			dataURL, err := dataurl.DecodeString(t.Image)
-			if err != nil {
-				s.Respond(
-					w,
-					r,
-					http.StatusBadRequest,
-					errors.New("could not decode base64 encoded data from payload"),
-				)
-				return
-			} else {
-				filedata = dataURL.Data
-			}
-		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("image data should start with \"data:image/jpeg;base64,\""))
-			return
+		if mimetype := mime.TypeByExtension(path.Ext(media)); mimetype != "" {
+			w.Header().Set("Content-Type", mimetype)
 		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", path.Base(media)))
+		http.ServeContent(w, r, path.Base(media), info.modTime, file)
+	}
+}
 
-		picture_id, err := clientPointer[userid].SetGroupPhoto(group, filedata)
+// Gets media retention settings
+func (s *server) GetMediaSettings() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
 
+		settings, err := getMediaSettings(s.db, userid)
 		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group photo")
-			msg := fmt.Sprintf("Failed to set group photo: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get media settings: %v", err))
 			return
 		}
 
-		response := map[string]interface{}{
-			"Details":   "Group Photo set successfully",
-			"PictureID": picture_id,
-		}
-		responseJson, err := json.Marshal(response)
-
+		responseJson, err := json.Marshal(settings)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
-
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// Set group name
-func (s *server) SetGroupName() http.HandlerFunc {
+// Sets media retention settings, only the fields present in the payload are changed
+func (s *server) SetMediaSettings() http.HandlerFunc {
 
-	type setGroupNameStruct struct {
-		GroupJID string
-		Name     string
+	type mediaSettingsStruct struct {
+		RetentionDays *int
+		QuotaBytes    *int64
+		Download      []string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		token := r.Context().Value("userinfo").(Values).Get("Token")
 		userid, _ := strconv.Atoi(txtid)
 
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
-			return
-		}
-
 		decoder := json.NewDecoder(r.Body)
-		var t setGroupNameStruct
+		var t mediaSettingsStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		group, ok := parseJID(t.GroupJID)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+		settings, err := getMediaSettings(s.db, userid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get media settings: %v", err))
 			return
 		}
-
-		if t.Name == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing name in payload"))
-			return
+		if t.RetentionDays != nil {
+			if *t.RetentionDays < 0 {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("RetentionDays can not be negative"))
+				return
+			}
+			settings.RetentionDays = *t.RetentionDays
+		}
+		if t.QuotaBytes != nil {
+			if *t.QuotaBytes < 0 {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("QuotaBytes can not be negative"))
+				return
+			}
+			settings.QuotaBytes = *t.QuotaBytes
+		}
+		if t.Download != nil {
+			settings.Download = []string{}
+			for _, mediaType := range t.Download {
+				if !Find(mediaTypes, mediaType) {
+					s.Respond(w, r, http.StatusBadRequest, fmt.Errorf("unknown media type %s", mediaType))
+					return
+				}
+				if !Find(settings.Download, mediaType) {
+					settings.Download = append(settings.Download, mediaType)
+				}
+			}
 		}
 
-		err = clientPointer[userid].SetGroupName(group, t.Name)
-
+		download := strings.Join(settings.Download, ",")
+		_, err = s.db.Exec(
+			"UPDATE users SET media_retention=?,media_quota=?,media_download=? WHERE id=?",
+			settings.RetentionDays, settings.QuotaBytes, download, userid,
+		)
 		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group name")
-			msg := fmt.Sprintf("Failed to set group name: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not set media settings: %v", err))
 			return
 		}
+		v := updateUserInfo(r.Context().Value("userinfo"), "MediaDownload", download)
+		userinfocache.Set(token, v, cache.NoExpiration)
 
-		response := map[string]interface{}{"Details": "Group Name set successfully"}
-		responseJson, err := json.Marshal(response)
+		// Apply new limits right away instead of waiting for the janitor
+		go func() {
+			if err := enforceRetention(s.db, userid, settings); err != nil {
+				log.Error().Err(err).Str("userid", txtid).Msg("Could not apply media retention")
+			}
+		}()
 
+		responseJson, err := json.Marshal(settings)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
-
 		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
-// Create a User
-func (s *server) CreateUser() http.HandlerFunc {
-	type createUserStruct struct {
-		Name  string
-		Token string
-	}
-
+// Gets how incoming calls are handled
+func (s *server) GetCallSettings() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		userid, _ := strconv.Atoi(txtid)
-		name := ""
 
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		settings, err := getCallSettings(s.db, userid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get call settings: %v", err))
 			return
 		}
 
-		log.Info().Msg("Looking for user information in DB")
-		// Checks DB from matching user and store user values in context
-		rows, err := s.db.Query("SELECT name FROM users WHERE id=? LIMIT 1", txtid)
-
+		responseJson, err := json.Marshal(settings)
 		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Error querying DB")
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("error querying db"))
+			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
 
-		defer rows.Close()
-		for rows.Next() {
-			if err := rows.Scan(&name); err != nil {
-				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Error scanning DB")
-				s.Respond(w, r, http.StatusInternalServerError, errors.New("error scanning db"))
-				return
-			}
-		}
+// Sets whether incoming calls are rejected and the text sent to callers, only the fields present in the payload are changed
+func (s *server) SetCallSettings() http.HandlerFunc {
 
-		// if name not start with "super-" then return Unauthorized
-		if !strings.HasPrefix(name, "super-") {
-			s.Respond(w, r, http.StatusUnauthorized, errors.New("Unauthorized"))
-			return
-		}
+	type callSettingsStruct struct {
+		Reject        *bool
+		RejectMessage *string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
 
 		decoder := json.NewDecoder(r.Body)
-		var t createUserStruct
-		err = decoder.Decode(&t)
+		var t callSettingsStruct
+		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		if t.Name == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing name in payload"))
+		settings, err := getCallSettings(s.db, userid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get call settings: %v", err))
 			return
 		}
-
-		if t.Token == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing token in payload"))
-			return
+		if t.Reject != nil {
+			settings.Reject = *t.Reject
+		}
+		if t.RejectMessage != nil {
+			settings.RejectMessage = *t.RejectMessage
 		}
 
-		// Check if user already exists
-		rows, err = s.db.Query("SELECT id FROM users WHERE token=? LIMIT 1", t.Token)
+		_, err = s.db.Exec("UPDATE users SET call_reject=?,call_reject_message=? WHERE id=?", settings.Reject, settings.RejectMessage, userid)
 		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Error querying DB")
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("error querying db"))
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not set call settings: %v", err))
 			return
 		}
 
-		defer rows.Close()
-		for rows.Next() {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("user already exists"))
+		responseJson, err := json.Marshal(settings)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
 
-		// Create user
-		stmt, err := s.db.Prepare("INSERT INTO users(name, token) VALUES(?, ?)")
+// Gets storage used by saved media files
+func (s *server) GetMediaUsage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		usage, err := getMediaUsage(userid)
 		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Error preparing DB statement")
-			s.Respond(
-				w,
-				r,
-				http.StatusInternalServerError,
-				errors.New("error preparing db statement"),
-			)
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get media usage: %v", err))
 			return
 		}
-
-		res, err := stmt.Exec(t.Name, t.Token)
+		settings, err := getMediaSettings(s.db, userid)
 		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Error executing DB statement")
-			s.Respond(
-				w,
-				r,
-				http.StatusInternalServerError,
-				errors.New("error executing db statement"),
-			)
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get media settings: %v", err))
 			return
 		}
 
-		_, err = res.LastInsertId()
+		response := map[string]interface{}{"Bytes": usage.Bytes, "Files": usage.Files, "ByType": usage.ByType, "QuotaBytes": settings.QuotaBytes}
+		responseJson, err := json.Marshal(response)
 		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Error getting last insert id")
-			s.Respond(
-				w,
-				r,
-				http.StatusInternalServerError,
-				errors.New("error getting last insert id"),
-			)
+			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
-
-		// return response
-		s.Respond(w, r, http.StatusOK, "User created successfully")
+		s.Respond(w, r, http.StatusOK, string(responseJson))
 	}
 }
 
@@ -3023,35 +4198,3 @@ func (s *server) Respond(w http.ResponseWriter, r *http.Request, status int, dat
 		panic("respond: " + err.Error())
 	}
 }
-
-func validateMessageFields(phone string, stanzaid *string, participant *string) (types.JID, error) {
-
-	recipient, ok := parseJID(phone)
-	if !ok {
-		return types.NewJID("", types.DefaultUserServer), errors.New("could not parse phone")
-	}
-
-	if stanzaid != nil {
-		if participant == nil {
-			return types.NewJID(
-					"",
-					types.DefaultUserServer,
-				), errors.New(
-					"missing participant in contextinfo",
-				)
-		}
-	}
-
-	if participant != nil {
-		if stanzaid == nil {
-			return types.NewJID(
-					"",
-					types.DefaultUserServer,
-				), errors.New(
-					"missing stanzaid in contextinfo",
-				)
-		}
-	}
-
-	return recipient, nil
-}
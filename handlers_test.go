@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestParseSubscriptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []string
+		want   []string
+	}{
+		{"none", nil, []string{"All"}},
+		{"valid", []string{"Message", "ReadReceipt"}, []string{"Message", "ReadReceipt"}},
+		{"duplicates", []string{"Message", "Message", "Call"}, []string{"Message", "Call"}},
+		{"unknown discarded", []string{"Message", "Bogus"}, []string{"Message"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSubscriptions(tt.events); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSubscriptions(%v) = %v, want %v", tt.events, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteUserData(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	count := func(table string, userColumn string, id int) int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE "+userColumn+"=?", id).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	mustExec := func(query string, args ...interface{}) {
+		if _, err := db.Exec(query, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mustExec("CREATE TABLE users (id INTEGER NOT NULL PRIMARY KEY)")
+	mustExec("INSERT INTO users(id) VALUES(1),(2)")
+	// The last table is created later, so the first delete fails and must not remove anything
+	for _, table := range userDataTables[:len(userDataTables)-1] {
+		mustExec("CREATE TABLE " + table + " (user_id INTEGER NOT NULL)")
+		mustExec("INSERT INTO " + table + "(user_id) VALUES(1),(2)")
+	}
+
+	if err := deleteUserData(db, 1); err == nil {
+		t.Fatal("deleteUserData() succeeded with a table missing")
+	}
+	for _, table := range userDataTables[:len(userDataTables)-1] {
+		if n := count(table, "user_id", 1); n != 1 {
+			t.Errorf("%s has %d rows of user 1 after a failed delete, want 1", table, n)
+		}
+	}
+
+	last := userDataTables[len(userDataTables)-1]
+	mustExec("CREATE TABLE " + last + " (user_id INTEGER NOT NULL)")
+	mustExec("INSERT INTO " + last + "(user_id) VALUES(1),(2)")
+	if err := deleteUserData(db, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range userDataTables {
+		if n := count(table, "user_id", 1); n != 0 {
+			t.Errorf("%s has %d rows of the deleted user", table, n)
+		}
+		if n := count(table, "user_id", 2); n != 1 {
+			t.Errorf("%s has %d rows of the other user, want 1", table, n)
+		}
+	}
+	if count("users", "id", 1) != 0 || count("users", "id", 2) != 1 {
+		t.Error("users table not updated as expected")
+	}
+}
+
+func TestGetMedia(t *testing.T) {
+	defer func(store mediaStorage) { mediaStore = store }(mediaStore)
+	mediaStore = newLocalStorage(t.TempDir())
+	for key, data := range map[string]string{"user_1/3EB0A1.jpg": "0123456789", "user_1/3EB0A4-old.jpg": "older chat", "user_1/3EB0A4.jpg": "newer chat"} {
+		if err := mediaStore.Put(key, []byte(data), "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE messages (user_id INTEGER NOT NULL, id TEXT NOT NULL, timestamp INTEGER NOT NULL, media TEXT NOT NULL default "");
+	INSERT INTO messages(user_id,id,timestamp,media) VALUES(1,'3EB0A1',1687340000,'user_1/3EB0A1.jpg'),(1,'3EB0A2',1687340001,'user_1/3EB0A2.jpg'),(1,'3EB0A3',1687340002,''),
+		(1,'3EB0A4',1687340003,'user_1/3EB0A4-old.jpg'),(1,'3EB0A4',1687340004,'user_1/3EB0A4.jpg'),(1,'3EB0A4',1687340005,'')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{db: db}
+
+	tests := []struct {
+		name   string
+		userID string
+		id     string
+		rng    string
+		status int
+		body   string
+	}{
+		{"whole file", "1", "3EB0A1", "", http.StatusOK, "0123456789"},
+		{"range", "1", "3EB0A1", "bytes=4-", http.StatusPartialContent, "456789"},
+		{"message of another user", "2", "3EB0A1", "", http.StatusNotFound, ""},
+		{"message without media", "1", "3EB0A3", "", http.StatusNotFound, ""},
+		{"file no longer stored", "1", "3EB0A2", "", http.StatusNotFound, ""},
+		{"id shared by several chats gets the newest media", "1", "3EB0A4", "", http.StatusOK, "newer chat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/media/"+tt.id, nil)
+			r = r.WithContext(context.WithValue(r.Context(), "userinfo", Values{map[string]string{"Id": tt.userID}}))
+			r = mux.SetURLVars(r, map[string]string{"id": tt.id})
+			if tt.rng != "" {
+				r.Header.Set("Range", tt.rng)
+			}
+			w := httptest.NewRecorder()
+			s.GetMedia()(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.body != "" {
+				if w.Body.String() != tt.body || w.Header().Get("Content-Type") != "image/jpeg" {
+					t.Errorf("served %q as %s, want %q as image/jpeg", w.Body, w.Header().Get("Content-Type"), tt.body)
+				}
+			}
+		})
+	}
+}
@@ -1,5 +1,20 @@
 package main
 
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime/multipart"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
 func Find(slice []string, val string) bool {
 	for _, item := range slice {
 		if item == val {
@@ -16,20 +31,113 @@ func updateUserInfo(values interface{}, field string, value string) interface{}
 	return values
 }
 
+// Signs a webhook body, the signature is HMAC-SHA256 over "timestamp.body"
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Headers identifying and signing a webhook request
+func webhookHeaders(secret string, body []byte) map[string]string {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	headers := map[string]string{"X-Wuzapi-Timestamp": timestamp}
+	if secret != "" {
+		headers["X-Wuzapi-Signature"] = signWebhook(secret, timestamp, body)
+	}
+	return headers
+}
+
+// Checks webhook response, any non 2xx status is a failed delivery
+func checkHookResponse(resp *resty.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode())
+	}
+	return nil
+}
+
+// Encodes the form fields of a webhook, as multipart when a file is attached.
+// Returns the exact body sent, which is what gets signed, and its content type.
+func formWebhookBody(payload map[string]string, name string, file []byte) ([]byte, string, error) {
+	keys := make([]string, 0, len(payload))
+	for key := range payload {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if file == nil {
+		values := url.Values{}
+		for _, key := range keys {
+			values.Set(key, payload[key])
+		}
+		return []byte(values.Encode()), "application/x-www-form-urlencoded", nil
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, key := range keys {
+		if err := writer.WriteField(key, payload[key]); err != nil {
+			return nil, "", err
+		}
+	}
+	part, err := writer.CreateFormFile("file", name)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err = part.Write(file); err != nil {
+		return nil, "", err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), writer.FormDataContentType(), nil
+}
+
 // webhook for regular messages
-func callHook(myurl string, payload map[string]string, id int) {
+func callHook(myurl string, payload map[string]string, id int, secret string) error {
 	log.Info().Str("url", myurl).Msg("Sending POST")
-	_, err := clientHttp[id].R().SetFormData(payload).Post(myurl)
-
+	body, contentType, err := formWebhookBody(payload, "", nil)
 	if err != nil {
-		log.Debug().Str("error", err.Error())
+		return err
 	}
+	resp, err := webhookClient(id).R().
+		SetHeaders(webhookHeaders(secret, body)).
+		SetHeader("Content-Type", contentType).
+		SetBody(body).
+		Post(myurl)
+
+	return checkHookResponse(resp, err)
 }
 
 // webhook for messages with file attachments
-func callHookFile(myurl string, payload map[string]string, id int, file string) {
-	log.Info().Str("file", file).Str("url", myurl).Msg("Sending POST")
-	clientHttp[id].R().SetFiles(map[string]string{
-		"file": file,
-	}).SetFormData(payload).Post(myurl)
+func callHookFile(myurl string, payload map[string]string, id int, name string, file []byte, secret string) error {
+	log.Info().Str("file", name).Str("url", myurl).Msg("Sending POST")
+	body, contentType, err := formWebhookBody(payload, name, file)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient(id).R().
+		SetHeaders(webhookHeaders(secret, body)).
+		SetHeader("Content-Type", contentType).
+		SetBody(body).
+		Post(myurl)
+
+	return checkHookResponse(resp, err)
+}
+
+// webhook posting the event as an application/json body
+func callHookJSON(myurl string, body []byte, id int, secret string) error {
+	log.Info().Str("url", myurl).Msg("Sending JSON POST")
+	resp, err := webhookClient(id).R().
+		SetHeaders(webhookHeaders(secret, body)).
+		SetHeader("Content-Type", "application/json").
+		SetBody(body).
+		Post(myurl)
+
+	return checkHookResponse(resp, err)
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestSignWebhook(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      string
+		want      string
+	}{
+		{"json body", "topsecret", "1687000000", `{"type":"Message"}`, "sha256=d46a3e4cba3bcc7baa27af245806fadc633dd98c6cfd7737337884970cc1713a"},
+		{"timestamp is signed", "topsecret", "1687000001", `{"type":"Message"}`, ""},
+		{"secret is used", "othersecret", "1687000000", `{"type":"Message"}`, ""},
+	}
+	reference := signWebhook(tests[0].secret, tests[0].timestamp, []byte(tests[0].body))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := signWebhook(tt.secret, tt.timestamp, []byte(tt.body))
+			if tt.want != "" && got != tt.want {
+				t.Errorf("signWebhook() = %s, want %s", got, tt.want)
+			}
+			if tt.want == "" && got == reference {
+				t.Errorf("signWebhook() = %s, same as with a different input", got)
+			}
+		})
+	}
+}
+
+func TestWebhookHeaders(t *testing.T) {
+	body := []byte(`{"type":"Message"}`)
+	headers := webhookHeaders("topsecret", body)
+	timestamp := headers["X-Wuzapi-Timestamp"]
+	if timestamp == "" {
+		t.Fatal("missing X-Wuzapi-Timestamp")
+	}
+	if got, want := headers["X-Wuzapi-Signature"], signWebhook("topsecret", timestamp, body); got != want {
+		t.Errorf("X-Wuzapi-Signature = %s, want %s", got, want)
+	}
+
+	headers = webhookHeaders("", body)
+	if _, ok := headers["X-Wuzapi-Signature"]; ok {
+		t.Error("webhook without secret was signed")
+	}
+}
+
+func TestFormWebhookBody(t *testing.T) {
+	payload := map[string]string{"jsonData": `{"type":"Message"}`, "instanceName": "main", "a&b": "c=d"}
+
+	body, contentType, err := formWebhookBody(payload, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "application/x-www-form-urlencoded" {
+		t.Errorf("content type = %s", contentType)
+	}
+	if want := "a%26b=c%3Dd&instanceName=main&jsonData=%7B%22type%22%3A%22Message%22%7D"; string(body) != want {
+		t.Errorf("form body = %s, want %s", body, want)
+	}
+
+	body, contentType, err = formWebhookBody(payload, "photo.jpg", []byte("jpeg data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		t.Fatalf("content type = %s, %v", contentType, err)
+	}
+	form, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range payload {
+		if got := form.Value[key]; len(got) != 1 || got[0] != value {
+			t.Errorf("field %s = %v, want %q", key, got, value)
+		}
+	}
+	if files := form.File["file"]; len(files) != 1 || files[0].Filename != "photo.jpg" {
+		t.Fatalf("file = %v", form.File)
+	}
+	file, _ := form.File["file"][0].Open()
+	data, _ := io.ReadAll(file)
+	if string(data) != "jpeg data" {
+		t.Errorf("file data = %q", data)
+	}
+}
+
+// The signature must cover the exact bytes received, whatever the body encoding
+func TestCallHookSignsSentBody(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	payload := map[string]string{"jsonData": `{"type":"Message"}`, "instanceName": "main"}
+	tests := []struct {
+		name string
+		send func() error
+	}{
+		{"form", func() error { return callHook(srv.URL, payload, 0, "topsecret") }},
+		{"multipart", func() error { return callHookFile(srv.URL, payload, 0, "photo.jpg", []byte("jpeg data"), "topsecret") }},
+		{"json", func() error { return callHookJSON(srv.URL, []byte(`{"type":"Message"}`), 0, "topsecret") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.send(); err != nil {
+				t.Fatal(err)
+			}
+			r, body := <-received, <-bodies
+			want := signWebhook("topsecret", r.Header.Get("X-Wuzapi-Timestamp"), body)
+			if got := r.Header.Get("X-Wuzapi-Signature"); !hmac.Equal([]byte(got), []byte(want)) {
+				t.Errorf("X-Wuzapi-Signature = %s, want %s", got, want)
+			}
+			if tt.name == "form" {
+				values, err := url.ParseQuery(string(body))
+				if err != nil || values.Get("jsonData") != payload["jsonData"] {
+					t.Errorf("form body = %s, %v", body, err)
+				}
+			}
+		})
+	}
+}
@@ -28,23 +28,49 @@ type server struct {
 }
 
 var (
-	address    = flag.String("address", "0.0.0.0", "Bind IP Address")
-	port       = flag.String("port", "8080", "Listen Port")
-	waDebug    = flag.String("wadebug", "", "Enable whatsmeow debug (INFO or DEBUG)")
-	logType    = flag.String("logtype", "console", "Type of log output (console or json)")
-	sslcert    = flag.String("sslcertificate", "", "SSL Certificate File")
-	sslprivkey = flag.String("sslprivatekey", "", "SSL Certificate Private Key File")
-	container  *sqlstore.Container
+	address            = flag.String("address", "0.0.0.0", "Bind IP Address")
+	port               = flag.String("port", "8080", "Listen Port")
+	waDebug            = flag.String("wadebug", "", "Enable whatsmeow debug (INFO or DEBUG)")
+	logType            = flag.String("logtype", "console", "Type of log output (console or json)")
+	sslcert            = flag.String("sslcertificate", "", "SSL Certificate File")
+	sslprivkey         = flag.String("sslprivatekey", "", "SSL Certificate Private Key File")
+	queueMaxAttempts   = flag.Int("queuemaxattempts", 5, "Maximum send attempts for queued messages before marking them as failed")
+	outboxRetention    = flag.Int("outboxretention", 7, "Days to keep the delivery status of messages no longer queued, 0 to keep it forever")
+	webhookRetries     = flag.Int("webhookretries", 5, "Maximum webhook delivery attempts before moving it to the dead letter table")
+	webhookToken       = flag.Bool("webhooktoken", false, "Send the user token in form webhooks, for receivers relying on older versions")
+	webhookBackoffSecs = flag.Int("webhookbackoff", 10, "Seconds to wait before the first webhook retry, doubled on each attempt")
+	adminToken         = flag.String("admintoken", "", "Token for the /admin API (defaults to WUZAPI_ADMIN_TOKEN env)")
+	publicURL          = flag.String("publicurl", "", "Base URL used for media links sent in webhooks, media is uploaded with the webhook when not set")
+	maxMediaSize       = flag.Int("maxmediasize", 100, "Maximum size in MB of media uploaded or fetched from URLs to be sent")
+	ffmpegPath         = flag.String("ffmpeg", "ffmpeg", "ffmpeg binary used to convert voice notes and stickers and to generate thumbnails, empty to disable conversion")
+	storageType        = flag.String("storage", "local", "Storage for received media and history sync files (local or s3)")
+	s3Endpoint         = flag.String("s3endpoint", "", "S3 compatible endpoint URL, e.g. https://s3.us-east-1.amazonaws.com")
+	s3Bucket           = flag.String("s3bucket", "", "S3 bucket for media storage")
+	s3Region           = flag.String("s3region", "us-east-1", "S3 region used for request signing")
+	s3AccessKey        = flag.String("s3accesskey", "", "S3 access key (defaults to AWS_ACCESS_KEY_ID env)")
+	s3SecretKey        = flag.String("s3secretkey", "", "S3 secret key (defaults to AWS_SECRET_ACCESS_KEY env)")
+	container          *sqlstore.Container
 
 	killchannel   = make(map[int](chan bool))
 	userinfocache = cache.New(5*time.Minute, 10*time.Minute)
 	log           zerolog.Logger
 )
 
-func init() {
+// Parses the flags and sets up logging, kept out of init so tests can define their own flags
+func configure() {
 
 	flag.Parse()
 
+	if *adminToken == "" {
+		*adminToken = os.Getenv("WUZAPI_ADMIN_TOKEN")
+	}
+	if *s3AccessKey == "" {
+		*s3AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if *s3SecretKey == "" {
+		*s3SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
 	if *logType == "json" {
 		log = zerolog.New(os.Stdout).
 			With().
@@ -59,6 +85,8 @@ func init() {
 
 func main() {
 
+	configure()
+
 	ex, err := os.Executable()
 	if err != nil {
 		panic(err)
@@ -74,19 +102,137 @@ func main() {
 		}
 	}
 
-	db, err := sql.Open("sqlite", exPath+"/dbdata/users.db")
+	db, err := sql.Open("sqlite", exPath+"/dbdata/users.db?_pragma=busy_timeout(3000)")
 	if err != nil {
 		log.Fatal().Err(err).Msg("Could not open/create " + exPath + "/dbdata/users.db")
 		os.Exit(1)
 	}
 	defer db.Close()
 
-	sqlStmt := `CREATE TABLE IF NOT EXISTS users (id INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL, token TEXT NOT NULL, webhook TEXT NOT NULL default "", jid TEXT NOT NULL default "", qrcode TEXT NOT NULL default "", connected INTEGER, expiration INTEGER, events TEXT NOT NULL default "All");`
+	sqlStmt := `CREATE TABLE IF NOT EXISTS users (id INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL, token TEXT NOT NULL, webhook TEXT NOT NULL default "", jid TEXT NOT NULL default "", qrcode TEXT NOT NULL default "", connected INTEGER, expiration INTEGER, events TEXT NOT NULL default "All", webhook_format TEXT NOT NULL default "form", webhook_secret TEXT NOT NULL default "", webhook_media TEXT NOT NULL default "upload", webhook_schema TEXT NOT NULL default "legacy", webhook_raw INTEGER NOT NULL default 0, call_reject INTEGER NOT NULL default 0, call_reject_message TEXT NOT NULL default "", media_retention INTEGER NOT NULL default 0, media_quota INTEGER NOT NULL default 0, media_download TEXT NOT NULL default "image,audio,document");`
 	_, err = db.Exec(sqlStmt)
 	if err != nil {
 		panic(fmt.Sprintf("%q: %s\n", err, sqlStmt))
 	}
 
+	// Upgrade users tables created by older versions
+	err = addColumn(db, "users", "webhook_format", `TEXT NOT NULL default "form"`)
+	if err != nil {
+		panic(err)
+	}
+	err = addColumn(db, "users", "webhook_secret", `TEXT NOT NULL default ""`)
+	if err != nil {
+		panic(err)
+	}
+	err = addColumn(db, "users", "webhook_media", `TEXT NOT NULL default "upload"`)
+	if err != nil {
+		panic(err)
+	}
+	err = addColumn(db, "users", "webhook_schema", `TEXT NOT NULL default "legacy"`)
+	if err != nil {
+		panic(err)
+	}
+	err = addColumn(db, "users", "webhook_raw", `INTEGER NOT NULL default 0`)
+	if err != nil {
+		panic(err)
+	}
+	err = addColumn(db, "users", "call_reject", `INTEGER NOT NULL default 0`)
+	if err != nil {
+		panic(err)
+	}
+	err = addColumn(db, "users", "call_reject_message", `TEXT NOT NULL default ""`)
+	if err != nil {
+		panic(err)
+	}
+	err = addColumn(db, "users", "media_retention", `INTEGER NOT NULL default 0`)
+	if err != nil {
+		panic(err)
+	}
+	err = addColumn(db, "users", "media_quota", `INTEGER NOT NULL default 0`)
+	if err != nil {
+		panic(err)
+	}
+	err = addColumn(db, "users", "media_download", `TEXT NOT NULL default "image,audio,document"`)
+	if err != nil {
+		panic(err)
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS outbox (id TEXT NOT NULL, user_id INTEGER NOT NULL, recipient TEXT NOT NULL, payload BLOB NOT NULL, status TEXT NOT NULL, attempts INTEGER NOT NULL default 0, next_attempt INTEGER NOT NULL default 0, last_error TEXT NOT NULL default "", created_at INTEGER NOT NULL, updated_at INTEGER NOT NULL, PRIMARY KEY (user_id, id));`
+	_, err = db.Exec(sqlStmt)
+	if err != nil {
+		panic(fmt.Sprintf("%q: %s\n", err, sqlStmt))
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS webhook_queue (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, user_id INTEGER NOT NULL, webhook_id INTEGER NOT NULL default 0, url TEXT NOT NULL, format TEXT NOT NULL, event_type TEXT NOT NULL, payload TEXT NOT NULL, file TEXT NOT NULL default "", attempts INTEGER NOT NULL default 0, next_attempt INTEGER NOT NULL, last_error TEXT NOT NULL default "", created_at INTEGER NOT NULL);`
+	_, err = db.Exec(sqlStmt)
+	if err != nil {
+		panic(fmt.Sprintf("%q: %s\n", err, sqlStmt))
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS webhook_deadletter (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, user_id INTEGER NOT NULL, webhook_id INTEGER NOT NULL default 0, url TEXT NOT NULL, format TEXT NOT NULL, event_type TEXT NOT NULL, payload TEXT NOT NULL, file TEXT NOT NULL default "", attempts INTEGER NOT NULL, last_error TEXT NOT NULL, created_at INTEGER NOT NULL, failed_at INTEGER NOT NULL);`
+	_, err = db.Exec(sqlStmt)
+	if err != nil {
+		panic(fmt.Sprintf("%q: %s\n", err, sqlStmt))
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS webhooks (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, user_id INTEGER NOT NULL, url TEXT NOT NULL, events TEXT NOT NULL default "All", format TEXT NOT NULL default "json", secret TEXT NOT NULL default "", media TEXT NOT NULL default "upload", schema TEXT NOT NULL default "legacy", raw INTEGER NOT NULL default 0, created_at INTEGER NOT NULL);`
+	_, err = db.Exec(sqlStmt)
+	if err != nil {
+		panic(fmt.Sprintf("%q: %s\n", err, sqlStmt))
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS messages (seq INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, user_id INTEGER NOT NULL, id TEXT NOT NULL, chat TEXT NOT NULL, sender TEXT NOT NULL, from_me INTEGER NOT NULL default 0, timestamp INTEGER NOT NULL, type TEXT NOT NULL, text TEXT NOT NULL default "", media TEXT NOT NULL default "", quoted_id TEXT NOT NULL default "", raw BLOB, UNIQUE (user_id, chat, id));
+	CREATE INDEX IF NOT EXISTS messages_chat ON messages (user_id, chat, timestamp);
+	CREATE INDEX IF NOT EXISTS messages_timestamp ON messages (user_id, timestamp);
+	CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(text, content='messages', content_rowid='seq');
+	CREATE TRIGGER IF NOT EXISTS messages_ai AFTER INSERT ON messages BEGIN
+		INSERT INTO messages_fts(rowid, text) VALUES (new.seq, new.text);
+	END;
+	CREATE TRIGGER IF NOT EXISTS messages_ad AFTER DELETE ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, text) VALUES ('delete', old.seq, old.text);
+	END;
+	CREATE TRIGGER IF NOT EXISTS messages_au AFTER UPDATE OF text ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, text) VALUES ('delete', old.seq, old.text);
+		INSERT INTO messages_fts(rowid, text) VALUES (new.seq, new.text);
+	END;`
+	_, err = db.Exec(sqlStmt)
+	if err != nil {
+		panic(fmt.Sprintf("%q: %s\n", err, sqlStmt))
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS chats (user_id INTEGER NOT NULL, jid TEXT NOT NULL, name TEXT NOT NULL default "", unread INTEGER NOT NULL default 0, archived INTEGER NOT NULL default 0, pinned INTEGER NOT NULL default 0, muted_until INTEGER NOT NULL default 0, last_activity INTEGER NOT NULL default 0, PRIMARY KEY (user_id, jid));`
+	_, err = db.Exec(sqlStmt)
+	if err != nil {
+		panic(fmt.Sprintf("%q: %s\n", err, sqlStmt))
+	}
+
+	sqlStmt = `CREATE TABLE IF NOT EXISTS poll_votes (user_id INTEGER NOT NULL, chat TEXT NOT NULL, poll_id TEXT NOT NULL, voter TEXT NOT NULL, options TEXT NOT NULL, timestamp INTEGER NOT NULL, PRIMARY KEY (user_id, chat, poll_id, voter));`
+	_, err = db.Exec(sqlStmt)
+	if err != nil {
+		panic(fmt.Sprintf("%q: %s\n", err, sqlStmt))
+	}
+
+	switch *storageType {
+	case "local":
+		mediaStore = newLocalStorage(exPath + "/files")
+	case "s3":
+		mediaStore, err = newS3Storage(*s3Endpoint, *s3Bucket, *s3Region, *s3AccessKey, *s3SecretKey)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not configure S3 storage")
+		}
+	default:
+		log.Fatal().Str("storage", *storageType).Msg("Unknown storage type")
+	}
+
+	if *ffmpegPath != "" {
+		ffmpeg, err := newFFmpegConverter(*ffmpegPath)
+		if err != nil {
+			log.Warn().Err(err).Msg("Media conversion disabled")
+		} else {
+			converter = ffmpeg
+		}
+	}
+
 	if *waDebug != "" {
 		dbLog := waLog.Stdout("Database", *waDebug, true)
 		container, err = sqlstore.New(
@@ -109,6 +255,9 @@ func main() {
 	s.routes()
 
 	s.connectOnStartup()
+	go s.webhookRetryWorker()
+	go s.mediaJanitor()
+	go s.outboxJanitor()
 
 	srv := &http.Server{
 		Addr:    *address + ":" + *port,
@@ -150,3 +299,21 @@ func main() {
 	}
 	log.Info().Msg("Server Exited Properly")
 }
+
+// Adds a column to a table if it does not exist yet
+func addColumn(db *sql.DB, table string, column string, definition string) error {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?", table, column).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("could not inspect table %s: %v", table, err)
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("could not add column %s to %s: %v", column, table, err)
+	}
+	log.Info().Str("table", table).Str("column", column).Msg("Database upgraded")
+	return nil
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestMain(m *testing.M) {
+	log = zerolog.Nop()
+	os.Exit(m.Run())
+}
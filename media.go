@@ -0,0 +1,190 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"mime"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const janitorInterval = time.Hour
+
+// Media types that can be downloaded automatically when received
+var mediaTypes = []string{"image", "audio", "document", "video"}
+
+// Retention settings of a user, zero values mean no limit
+type mediaSettings struct {
+	RetentionDays int
+	QuotaBytes    int64
+	Download      []string
+}
+
+// Storage used by a user
+type mediaUsage struct {
+	Bytes  int64
+	Files  int
+	ByType map[string]int64
+}
+
+// Prefix of the storage keys of a user
+func userMediaPrefix(userID int) string {
+	return fmt.Sprintf("user_%d/", userID)
+}
+
+// Parses the comma separated list of media types downloaded automatically
+func parseMediaDownload(download string) []string {
+	types := []string{}
+	for _, t := range strings.Split(download, ",") {
+		if Find(mediaTypes, t) {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+func getMediaSettings(db *sql.DB, userID int) (mediaSettings, error) {
+	var m mediaSettings
+	var download string
+	err := db.QueryRow("SELECT media_retention,media_quota,media_download FROM users WHERE id=?", userID).Scan(&m.RetentionDays, &m.QuotaBytes, &download)
+	m.Download = parseMediaDownload(download)
+	return m, err
+}
+
+// Lists files saved for a user, oldest first
+func listUserFiles(userID int) ([]storedFile, error) {
+	files, err := mediaStore.List(userMediaPrefix(userID))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	return files, nil
+}
+
+// Kind of a saved file for usage reports, history sync dumps are reported apart
+func fileKind(key string) string {
+	if strings.HasPrefix(path.Base(key), "history-") && path.Ext(key) == ".json" {
+		return "history"
+	}
+	mimetype := mime.TypeByExtension(path.Ext(key))
+	for _, kind := range []string{"image", "audio", "video"} {
+		if strings.HasPrefix(mimetype, kind+"/") {
+			return kind
+		}
+	}
+	return "document"
+}
+
+// Gets storage used by the files saved for a user
+func getMediaUsage(userID int) (mediaUsage, error) {
+	usage := mediaUsage{ByType: make(map[string]int64)}
+	files, err := listUserFiles(userID)
+	if err != nil {
+		return usage, err
+	}
+	for _, f := range files {
+		usage.Bytes += f.size
+		usage.Files++
+		usage.ByType[fileKind(f.key)] += f.size
+	}
+	return usage, nil
+}
+
+// Removes a saved file and the media reference of messages pointing to it
+func removeUserFile(db *sql.DB, userID int, f storedFile) error {
+	err := mediaStore.Delete(f.key)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE messages SET media='' WHERE user_id=? AND media=?", userID, f.key)
+	return err
+}
+
+// Removes all files saved for a user
+func removeAllUserFiles(userID int) error {
+	files, err := listUserFiles(userID)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		err = mediaStore.Delete(f.key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Applies the retention settings of a user, removing expired files and then the oldest ones over quota
+func enforceRetention(db *sql.DB, userID int, settings mediaSettings) error {
+	if settings.RetentionDays <= 0 && settings.QuotaBytes <= 0 {
+		return nil
+	}
+	files, err := listUserFiles(userID)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	removed := 0
+	cutoff := time.Now().AddDate(0, 0, -settings.RetentionDays)
+	for _, f := range files {
+		expired := settings.RetentionDays > 0 && f.modTime.Before(cutoff)
+		overQuota := settings.QuotaBytes > 0 && total > settings.QuotaBytes
+		if !expired && !overQuota {
+			break
+		}
+		if err = removeUserFile(db, userID, f); err != nil {
+			log.Warn().Err(err).Str("key", f.key).Msg("Could not remove media file")
+			continue
+		}
+		total -= f.size
+		removed++
+	}
+	if removed > 0 {
+		log.Info().Str("userid", strconv.Itoa(userID)).Int("files", removed).Int64("bytes", total).Msg("Media retention applied")
+	}
+	return nil
+}
+
+// Periodically removes media files according to each user retention settings
+func (s *server) mediaJanitor() {
+	for {
+		rows, err := s.db.Query("SELECT id,media_retention,media_quota FROM users WHERE media_retention>0 OR media_quota>0")
+		if err != nil {
+			log.Error().Err(err).Msg("Could not read media retention settings")
+		} else {
+			settings := make(map[int]mediaSettings)
+			for rows.Next() {
+				var id int
+				var m mediaSettings
+				if err = rows.Scan(&id, &m.RetentionDays, &m.QuotaBytes); err == nil {
+					settings[id] = m
+				}
+			}
+			rows.Close()
+			for id, m := range settings {
+				if err = enforceRetention(s.db, id, m); err != nil {
+					log.Error().Err(err).Str("userid", strconv.Itoa(id)).Msg("Could not apply media retention")
+				}
+			}
+		}
+		time.Sleep(janitorInterval)
+	}
+}
+
+// Whether received media of the given type should be saved
+func (mycli *MyClient) autoDownload(mediaType string) bool {
+	token, _ := mycli.session()
+	myuserinfo, found := userinfocache.Get(token)
+	if !found {
+		return true
+	}
+	return Find(parseMediaDownload(myuserinfo.(Values).Get("MediaDownload")), mediaType)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseMediaDownload(t *testing.T) {
+	tests := []struct {
+		download string
+		want     []string
+	}{
+		{"image,audio,document", []string{"image", "audio", "document"}},
+		{"video,bogus", []string{"video"}},
+		{"", []string{}},
+	}
+	for _, tt := range tests {
+		if got := parseMediaDownload(tt.download); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseMediaDownload(%q) = %v, want %v", tt.download, got, tt.want)
+		}
+	}
+}
+
+func TestFileKind(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"user_1/3EB0A1.jpg", "image"},
+		{"user_1/3EB0A2.ogg", "audio"},
+		{"user_1/3EB0A3.mp4", "video"},
+		{"user_1/3EB0A4.pdf", "document"},
+		{"user_1/3EB0A5", "document"},
+		{"user_1/history-1687340000.json", "history"},
+		{"user_1/notes.json", "document"},
+	}
+	for _, tt := range tests {
+		if got := fileKind(tt.key); got != tt.want {
+			t.Errorf("fileKind(%q) = %s, want %s", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestEnforceRetention(t *testing.T) {
+	now := time.Now()
+	files := []struct {
+		key  string
+		size int
+		age  time.Duration
+	}{
+		{"user_1/old.jpg", 100, 40 * 24 * time.Hour},
+		{"user_1/older.ogg", 100, 60 * 24 * time.Hour},
+		{"user_1/week.pdf", 300, 7 * 24 * time.Hour},
+		{"user_1/new.mp4", 400, time.Hour},
+		{"user_2/old.jpg", 100, 90 * 24 * time.Hour},
+	}
+
+	tests := []struct {
+		name     string
+		settings mediaSettings
+		want     []string
+	}{
+		{"no limits", mediaSettings{}, []string{"user_1/new.mp4", "user_1/old.jpg", "user_1/older.ogg", "user_1/week.pdf"}},
+		{"retention", mediaSettings{RetentionDays: 30}, []string{"user_1/new.mp4", "user_1/week.pdf"}},
+		{"quota removes the oldest", mediaSettings{QuotaBytes: 750}, []string{"user_1/new.mp4", "user_1/week.pdf"}},
+		{"quota after retention", mediaSettings{RetentionDays: 30, QuotaBytes: 500}, []string{"user_1/new.mp4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			defer func(store mediaStorage) { mediaStore = store }(mediaStore)
+			mediaStore = newLocalStorage(root)
+			for _, f := range files {
+				if err := mediaStore.Put(f.key, make([]byte, f.size), ""); err != nil {
+					t.Fatal(err)
+				}
+				modTime := now.Add(-f.age)
+				os.Chtimes(filepath.Join(root, filepath.FromSlash(f.key)), modTime, modTime)
+			}
+
+			db, err := sql.Open("sqlite", ":memory:")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+			_, err = db.Exec(`CREATE TABLE messages (user_id INTEGER NOT NULL, id TEXT NOT NULL, media TEXT NOT NULL default "");
+			INSERT INTO messages(user_id,id,media) VALUES(1,'OLD','user_1/old.jpg'),(1,'NEW','user_1/new.mp4')`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := enforceRetention(db, 1, tt.settings); err != nil {
+				t.Fatal(err)
+			}
+			remaining, err := listUserFiles(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, f := range remaining {
+				keys = append(keys, f.key)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("remaining files = %v, want %v", keys, tt.want)
+			}
+			if other, _ := listUserFiles(2); len(other) != 1 {
+				t.Errorf("files of another user were removed")
+			}
+
+			var media string
+			db.QueryRow("SELECT media FROM messages WHERE id='OLD'").Scan(&media)
+			if removed := media == ""; removed == Find(tt.want, "user_1/old.jpg") {
+				t.Errorf("media reference of old.jpg = %q", media)
+			}
+		})
+	}
+}
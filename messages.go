@@ -0,0 +1,313 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Message as stored in the messages table
+type storedMessage struct {
+	Id        string
+	Chat      string
+	Sender    string
+	FromMe    bool
+	Timestamp time.Time
+	Type      string
+	Text      string
+	Media     string
+	QuotedId  string
+}
+
+// Selection made on a buttons, template or list message
+type interactiveReply struct {
+	Type      string
+	Id        string
+	Title     string
+	MessageId string
+}
+
+// Returns the selection in a button, template or list response, nil for other messages
+func getInteractiveReply(msg *waProto.Message) *interactiveReply {
+	switch {
+	case msg.GetButtonsResponseMessage() != nil:
+		reply := msg.GetButtonsResponseMessage()
+		return &interactiveReply{Type: "button", Id: reply.GetSelectedButtonId(), Title: reply.GetSelectedDisplayText(), MessageId: reply.GetContextInfo().GetStanzaId()}
+	case msg.GetTemplateButtonReplyMessage() != nil:
+		reply := msg.GetTemplateButtonReplyMessage()
+		return &interactiveReply{Type: "template", Id: reply.GetSelectedId(), Title: reply.GetSelectedDisplayText(), MessageId: reply.GetContextInfo().GetStanzaId()}
+	case msg.GetListResponseMessage() != nil:
+		reply := msg.GetListResponseMessage()
+		return &interactiveReply{Type: "list", Id: reply.GetSingleSelectReply().GetSelectedRowId(), Title: reply.GetTitle(), MessageId: reply.GetContextInfo().GetStanzaId()}
+	}
+	return nil
+}
+
+// Returns the type, text and context info of a message, type is empty for messages not kept in history
+func messageContent(msg *waProto.Message) (string, string, *waProto.ContextInfo) {
+	switch {
+	case msg == nil:
+		return "", "", nil
+	case msg.Conversation != nil:
+		return "text", msg.GetConversation(), nil
+	case msg.ExtendedTextMessage != nil:
+		return "text", msg.ExtendedTextMessage.GetText(), msg.ExtendedTextMessage.GetContextInfo()
+	case msg.ImageMessage != nil:
+		return "image", msg.ImageMessage.GetCaption(), msg.ImageMessage.GetContextInfo()
+	case msg.AudioMessage != nil:
+		return "audio", "", msg.AudioMessage.GetContextInfo()
+	case msg.VideoMessage != nil:
+		return "video", msg.VideoMessage.GetCaption(), msg.VideoMessage.GetContextInfo()
+	case msg.DocumentMessage != nil:
+		text := msg.DocumentMessage.GetCaption()
+		if text == "" {
+			text = msg.DocumentMessage.GetFileName()
+		}
+		return "document", text, msg.DocumentMessage.GetContextInfo()
+	case msg.StickerMessage != nil:
+		return "sticker", "", msg.StickerMessage.GetContextInfo()
+	case msg.LocationMessage != nil:
+		return "location", msg.LocationMessage.GetName(), msg.LocationMessage.GetContextInfo()
+	case msg.ContactMessage != nil:
+		return "contact", msg.ContactMessage.GetDisplayName(), msg.ContactMessage.GetContextInfo()
+	case msg.ButtonsMessage != nil:
+		return "buttons", msg.ButtonsMessage.GetContentText(), msg.ButtonsMessage.GetContextInfo()
+	case msg.ListMessage != nil:
+		return "list", msg.ListMessage.GetDescription(), msg.ListMessage.GetContextInfo()
+	case msg.ButtonsResponseMessage != nil:
+		return "reply", msg.ButtonsResponseMessage.GetSelectedDisplayText(), msg.ButtonsResponseMessage.GetContextInfo()
+	case msg.TemplateButtonReplyMessage != nil:
+		return "reply", msg.TemplateButtonReplyMessage.GetSelectedDisplayText(), msg.TemplateButtonReplyMessage.GetContextInfo()
+	case msg.ListResponseMessage != nil:
+		return "reply", msg.ListResponseMessage.GetTitle(), msg.ListResponseMessage.GetContextInfo()
+	case pollCreation(msg) != nil:
+		return "poll", pollCreation(msg).GetName(), pollCreation(msg).GetContextInfo()
+	case msg.ViewOnceMessage != nil:
+		return messageContent(msg.ViewOnceMessage.GetMessage())
+	case msg.DocumentWithCaptionMessage != nil:
+		return messageContent(msg.DocumentWithCaptionMessage.GetMessage())
+	}
+	return "", "", nil
+}
+
+// Stores a message, keeping the media reference if it was already set.
+// Returns false for messages without content, like reactions or key distribution, which are not stored.
+func storeMessage(db *sql.DB, userID int, info *types.MessageInfo, msg *waProto.Message, media string) (bool, error) {
+	msgType, text, contextInfo := messageContent(msg)
+	if msgType == "" {
+		return false, nil
+	}
+	fromMe := 0
+	if info.IsFromMe {
+		fromMe = 1
+	}
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		return false, err
+	}
+	_, err = db.Exec(
+		`INSERT INTO messages(user_id,id,chat,sender,from_me,timestamp,type,text,media,quoted_id,raw) VALUES(?,?,?,?,?,?,?,?,?,?,?)
+		ON CONFLICT(user_id,chat,id) DO UPDATE SET type=excluded.type,text=excluded.text,media=CASE WHEN excluded.media<>'' THEN excluded.media ELSE messages.media END,quoted_id=excluded.quoted_id,raw=excluded.raw`,
+		userID, info.ID, info.Chat.ToNonAD().String(), info.Sender.ToNonAD().String(), fromMe, info.Timestamp.Unix(),
+		msgType, text, media, contextInfo.GetStanzaId(), raw,
+	)
+	return err == nil, err
+}
+
+// Stores a message and updates its chat, returns true if the chat was just created.
+// Only stored messages count as chat activity, status posts never do.
+func storeChatMessage(db *sql.DB, userID int, info *types.MessageInfo, msg *waProto.Message) (bool, error) {
+	stored, err := storeMessage(db, userID, info, msg, "")
+	if err != nil || !stored || info.Chat == types.StatusBroadcastJID {
+		return false, err
+	}
+	return updateChatForMessage(db, userID, info)
+}
+
+// Gets a stored message and its sender to quote it in a reply, the message is nil if it is not stored
+func getQuotedMessage(db *sql.DB, userID int, chat types.JID, id string) (*waProto.Message, string, error) {
+	var sender, text string
+	var raw []byte
+	err := db.QueryRow("SELECT sender,text,raw FROM messages WHERE user_id=? AND chat=? AND id=?", userID, chat.ToNonAD().String(), id).Scan(&sender, &text, &raw)
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	if len(raw) == 0 {
+		// Stored by older versions, only the text is known
+		return &waProto.Message{Conversation: proto.String(text)}, sender, nil
+	}
+	msg := &waProto.Message{}
+	err = proto.Unmarshal(raw, msg)
+	if err != nil {
+		return nil, "", err
+	}
+	return msg, sender, nil
+}
+
+// Sets the media reference of a stored message once its file was saved
+func setMessageMedia(db *sql.DB, userID int, chat types.JID, id string, media string) error {
+	_, err := db.Exec("UPDATE messages SET media=? WHERE user_id=? AND chat=? AND id=?", media, userID, chat.ToNonAD().String(), id)
+	return err
+}
+
+// Replaces the text of a stored message after it was edited, text messages also get the new content
+func editStoredMessage(db *sql.DB, userID int, chat types.JID, id string, msg *waProto.Message) error {
+	_, text, _ := messageContent(msg)
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		"UPDATE messages SET text=?,raw=CASE WHEN type='text' THEN ? ELSE raw END WHERE user_id=? AND chat=? AND id=? AND type<>'revoked'",
+		text, raw, userID, chat.ToNonAD().String(), id,
+	)
+	return err
+}
+
+// Marks a stored message as revoked, dropping its content
+func revokeStoredMessage(db *sql.DB, userID int, chat types.JID, id string) error {
+	_, err := db.Exec("UPDATE messages SET type='revoked',text='',raw=NULL WHERE user_id=? AND chat=? AND id=?", userID, chat.ToNonAD().String(), id)
+	return err
+}
+
+// Applies edits and revokes to the stored messages, setting the webhook event for them.
+// Returns false for any other message.
+func (mycli *MyClient) applyProtocolMessage(evt *events.Message, postmap map[string]interface{}) bool {
+	protocolMsg := evt.Message.GetProtocolMessage()
+	id := protocolMsg.GetKey().GetId()
+	if id == "" {
+		return false
+	}
+	switch protocolMsg.GetType() {
+	case waProto.ProtocolMessage_REVOKE:
+		postmap["type"] = "MessageRevoke"
+		postmap["messageId"] = id
+		log.Info().Str("id", id).Str("source", evt.Info.SourceString()).Msg("Message revoked")
+		err := revokeStoredMessage(mycli.db, mycli.userID, evt.Info.Chat, id)
+		if err != nil {
+			log.Error().Err(err).Str("id", id).Msg("Could not mark message as revoked")
+		}
+	case waProto.ProtocolMessage_MESSAGE_EDIT:
+		_, text, _ := messageContent(protocolMsg.GetEditedMessage())
+		postmap["type"] = "MessageEdit"
+		postmap["messageId"] = id
+		postmap["text"] = text
+		log.Info().Str("id", id).Str("source", evt.Info.SourceString()).Msg("Message edited")
+		err := editStoredMessage(mycli.db, mycli.userID, evt.Info.Chat, id, protocolMsg.GetEditedMessage())
+		if err != nil {
+			log.Error().Err(err).Str("id", id).Msg("Could not store edited message")
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// Stores a message sent through the API
+func storeSentMessage(db *sql.DB, userID int, client *whatsmeow.Client, recipient types.JID, msgid string, msg *waProto.Message, timestamp time.Time) {
+	if client.Store.ID == nil {
+		return
+	}
+	info := types.MessageInfo{
+		MessageSource: types.MessageSource{
+			Chat:     recipient,
+			Sender:   client.Store.ID.ToNonAD(),
+			IsFromMe: true,
+			IsGroup:  recipient.Server == types.GroupServer,
+		},
+		ID:        msgid,
+		Timestamp: timestamp,
+	}
+	_, err := storeChatMessage(db, userID, &info, msg)
+	if err != nil {
+		log.Warn().Err(err).Str("id", msgid).Msg("Could not store sent message")
+	}
+}
+
+// Stores the messages of all conversations in a history sync
+func (mycli *MyClient) storeHistorySync(data *waProto.HistorySync) {
+	tx, err := mycli.db.Begin()
+	if err != nil {
+		log.Error().Err(err).Msg("Could not store history sync")
+		return
+	}
+	stored := 0
+	for _, conv := range data.GetConversations() {
+		chatJID, err := types.ParseJID(conv.GetId())
+		if err != nil {
+			log.Warn().Err(err).Str("chat", conv.GetId()).Msg("Invalid chat in history sync")
+			continue
+		}
+		err = storeHistoryChat(tx, mycli.userID, chatJID, conv)
+		if err != nil {
+			tx.Rollback()
+			log.Error().Err(err).Msg("Could not store history sync")
+			return
+		}
+		for _, historyMsg := range conv.GetMessages() {
+			evt, err := mycli.WAClient.ParseWebMessage(chatJID, historyMsg.GetMessage())
+			if err != nil {
+				log.Warn().Err(err).Msg("Could not parse message in history sync")
+				continue
+			}
+			msgType, text, contextInfo := messageContent(evt.Message)
+			if msgType == "" {
+				continue
+			}
+			fromMe := 0
+			if evt.Info.IsFromMe {
+				fromMe = 1
+			}
+			raw, err := proto.Marshal(evt.Message)
+			if err != nil {
+				log.Warn().Err(err).Msg("Could not encode message in history sync")
+				continue
+			}
+			_, err = tx.Exec(
+				"INSERT OR IGNORE INTO messages(user_id,id,chat,sender,from_me,timestamp,type,text,media,quoted_id,raw) VALUES(?,?,?,?,?,?,?,?,'',?,?)",
+				mycli.userID, evt.Info.ID, chatJID.ToNonAD().String(), evt.Info.Sender.ToNonAD().String(), fromMe, evt.Info.Timestamp.Unix(),
+				msgType, text, contextInfo.GetStanzaId(), raw,
+			)
+			if err != nil {
+				tx.Rollback()
+				log.Error().Err(err).Msg("Could not store history sync")
+				return
+			}
+			stored++
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		log.Error().Err(err).Msg("Could not store history sync")
+		return
+	}
+	log.Info().Int("messages", stored).Msg("Stored history sync messages")
+}
+
+// Builds an FTS5 query matching all the given words, quoted so user input is never parsed as query syntax
+func searchQuery(search string) string {
+	var terms []string
+	for _, word := range strings.Fields(search) {
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// Parses a chat given as a phone number or a full user/group jid
+func parseChatJID(arg string) (types.JID, bool) {
+	if strings.ContainsRune(arg, '@') {
+		jid, err := types.ParseJID(arg)
+		if err != nil {
+			return jid, false
+		}
+		return jid.ToNonAD(), true
+	}
+	return parseJID(arg)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestGetInteractiveReply(t *testing.T) {
+	quoting := &waProto.ContextInfo{StanzaId: proto.String("3EB0A0")}
+	tests := []struct {
+		name string
+		msg  *waProto.Message
+		want *interactiveReply
+	}{
+		{
+			name: "button",
+			msg: &waProto.Message{ButtonsResponseMessage: &waProto.ButtonsResponseMessage{
+				Response:         &waProto.ButtonsResponseMessage_SelectedDisplayText{SelectedDisplayText: "Yes"},
+				SelectedButtonId: proto.String("yes"),
+				ContextInfo:      quoting,
+			}},
+			want: &interactiveReply{Type: "button", Id: "yes", Title: "Yes", MessageId: "3EB0A0"},
+		},
+		{
+			name: "template button",
+			msg: &waProto.Message{TemplateButtonReplyMessage: &waProto.TemplateButtonReplyMessage{
+				SelectedId:          proto.String("confirm"),
+				SelectedDisplayText: proto.String("Confirm"),
+				ContextInfo:         quoting,
+			}},
+			want: &interactiveReply{Type: "template", Id: "confirm", Title: "Confirm", MessageId: "3EB0A0"},
+		},
+		{
+			name: "list row",
+			msg: &waProto.Message{ListResponseMessage: &waProto.ListResponseMessage{
+				Title:             proto.String("Large"),
+				SingleSelectReply: &waProto.ListResponseMessage_SingleSelectReply{SelectedRowId: proto.String("size-l")},
+				ContextInfo:       quoting,
+			}},
+			want: &interactiveReply{Type: "list", Id: "size-l", Title: "Large", MessageId: "3EB0A0"},
+		},
+		{
+			name: "reply without context info",
+			msg: &waProto.Message{ListResponseMessage: &waProto.ListResponseMessage{
+				Title:             proto.String("Large"),
+				SingleSelectReply: &waProto.ListResponseMessage_SingleSelectReply{SelectedRowId: proto.String("size-l")},
+			}},
+			want: &interactiveReply{Type: "list", Id: "size-l", Title: "Large"},
+		},
+		{
+			name: "text message",
+			msg:  &waProto.Message{Conversation: proto.String("Yes")},
+		},
+		{
+			name: "nil message",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getInteractiveReply(tt.msg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getInteractiveReply() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageContent(t *testing.T) {
+	quote := &waProto.ContextInfo{StanzaId: proto.String("3EB0A0")}
+	tests := []struct {
+		name     string
+		msg      *waProto.Message
+		kind     string
+		text     string
+		hasQuote bool
+	}{
+		{"conversation", &waProto.Message{Conversation: proto.String("hi")}, "text", "hi", false},
+		{"extended text", &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{Text: proto.String("hi"), ContextInfo: quote}}, "text", "hi", true},
+		{"image caption", &waProto.Message{ImageMessage: &waProto.ImageMessage{Caption: proto.String("look"), ContextInfo: quote}}, "image", "look", true},
+		{"document name without caption", &waProto.Message{DocumentMessage: &waProto.DocumentMessage{FileName: proto.String("report.pdf")}}, "document", "report.pdf", false},
+		{"document caption", &waProto.Message{DocumentMessage: &waProto.DocumentMessage{FileName: proto.String("report.pdf"), Caption: proto.String("Q2")}}, "document", "Q2", false},
+		{"list reply", &waProto.Message{ListResponseMessage: &waProto.ListResponseMessage{Title: proto.String("Large"), ContextInfo: quote}}, "reply", "Large", true},
+		{"view once", &waProto.Message{ViewOnceMessage: &waProto.FutureProofMessage{Message: &waProto.Message{ImageMessage: &waProto.ImageMessage{Caption: proto.String("once")}}}}, "image", "once", false},
+		{"poll", &waProto.Message{PollCreationMessageV3: &waProto.PollCreationMessage{Name: proto.String("Lunch?")}}, "poll", "Lunch?", false},
+		{"not kept", &waProto.Message{ProtocolMessage: &waProto.ProtocolMessage{}}, "", "", false},
+		{"nil", nil, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, text, ci := messageContent(tt.msg)
+			if kind != tt.kind || text != tt.text {
+				t.Errorf("messageContent() = %q, %q, want %q, %q", kind, text, tt.kind, tt.text)
+			}
+			if (ci.GetStanzaId() != "") != tt.hasQuote {
+				t.Errorf("messageContent() context info = %v, want quote %v", ci, tt.hasQuote)
+			}
+		})
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	tests := []struct {
+		search string
+		want   string
+	}{
+		{"invoice", `"invoice"`},
+		{"  overdue   invoice ", `"overdue" "invoice"`},
+		{`say "hi" OR NOT x*`, `"say" """hi""" "OR" "NOT" "x*"`},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := searchQuery(tt.search); got != tt.want {
+			t.Errorf("searchQuery(%q) = %s, want %s", tt.search, got, tt.want)
+		}
+	}
+}
+
+func TestParseChatJID(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+		ok   bool
+	}{
+		{"5491155554444", "5491155554444@s.whatsapp.net", true},
+		{"5491155554444@s.whatsapp.net", "5491155554444@s.whatsapp.net", true},
+		{"5491155554444.0:3@s.whatsapp.net", "5491155554444@s.whatsapp.net", true},
+		{"120363025246125486@g.us", "120363025246125486@g.us", true},
+		{"5491155554444.0:x@s.whatsapp.net", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		jid, ok := parseChatJID(tt.arg)
+		if ok != tt.ok || (ok && jid.String() != tt.want) {
+			t.Errorf("parseChatJID(%q) = %s, %v, want %s, %v", tt.arg, jid, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Votes of a poll option as returned by /chat/poll/{id}
+type pollOption struct {
+	Name   string
+	Votes  int
+	Voters []string
+}
+
+// Current results of a poll, every voter counts once with their latest vote
+type pollTally struct {
+	Id              string
+	Chat            string
+	Name            string
+	SelectableCount uint32
+	Options         []pollOption
+	Voters          int
+}
+
+// Returns the poll in a message, whatever version of poll creation it uses
+func pollCreation(msg *waProto.Message) *waProto.PollCreationMessage {
+	switch {
+	case msg.GetPollCreationMessage() != nil:
+		return msg.GetPollCreationMessage()
+	case msg.GetPollCreationMessageV2() != nil:
+		return msg.GetPollCreationMessageV2()
+	case msg.GetPollCreationMessageV3() != nil:
+		return msg.GetPollCreationMessageV3()
+	}
+	return nil
+}
+
+// Gets a stored poll, nil if the message is not stored or is not a poll
+func getStoredPoll(db *sql.DB, userID int, chat types.JID, id string) (*waProto.PollCreationMessage, error) {
+	msg, _, err := getQuotedMessage(db, userID, chat, id)
+	if err != nil || msg == nil {
+		return nil, err
+	}
+	return pollCreation(msg), nil
+}
+
+// Maps the option hashes of a vote to the option names, hashes of unknown options are kept in hex
+func pollOptionNames(poll *waProto.PollCreationMessage, hashes [][]byte) []string {
+	names := make(map[string]string)
+	for _, option := range poll.GetOptions() {
+		name := option.GetOptionName()
+		names[hex.EncodeToString(whatsmeow.HashPollOptions([]string{name})[0])] = name
+	}
+	selected := make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		key := hex.EncodeToString(hash)
+		if name, ok := names[key]; ok {
+			selected = append(selected, name)
+		} else {
+			selected = append(selected, key)
+		}
+	}
+	return selected
+}
+
+// Stores a vote, replacing any previous vote of the same voter unless it is older
+func storePollVote(db *sql.DB, userID int, chat types.JID, pollID string, voter types.JID, options []string, timestamp time.Time) error {
+	encoded, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		`INSERT INTO poll_votes(user_id,chat,poll_id,voter,options,timestamp) VALUES(?,?,?,?,?,?)
+		ON CONFLICT(user_id,chat,poll_id,voter) DO UPDATE SET options=excluded.options,timestamp=excluded.timestamp WHERE excluded.timestamp>=poll_votes.timestamp`,
+		userID, chat.ToNonAD().String(), pollID, voter.ToNonAD().String(), string(encoded), timestamp.Unix(),
+	)
+	return err
+}
+
+// Decrypts and stores poll votes, setting the webhook event for them.
+// Returns false for any other message.
+func (mycli *MyClient) applyPollVote(evt *events.Message, postmap map[string]interface{}) bool {
+	pollUpdate := evt.Message.GetPollUpdateMessage()
+	if pollUpdate == nil {
+		return false
+	}
+	pollID := pollUpdate.GetPollCreationMessageKey().GetId()
+	postmap["type"] = "PollVote"
+	postmap["pollId"] = pollID
+
+	vote, err := mycli.WAClient.DecryptPollVote(evt)
+	if err != nil {
+		log.Error().Err(err).Str("id", evt.Info.ID).Str("poll", pollID).Msg("Could not decrypt poll vote")
+		return true
+	}
+	poll, err := getStoredPoll(mycli.db, mycli.userID, evt.Info.Chat, pollID)
+	if err != nil {
+		log.Warn().Err(err).Str("poll", pollID).Msg("Could not get poll")
+	}
+	options := pollOptionNames(poll, vote.GetSelectedOptions())
+	postmap["voter"] = evt.Info.Sender.ToNonAD().String()
+	postmap["options"] = options
+	log.Info().Str("poll", pollID).Str("source", evt.Info.SourceString()).Strs("options", options).Msg("Poll vote received")
+
+	err = storePollVote(mycli.db, mycli.userID, evt.Info.Chat, pollID, evt.Info.Sender, options, evt.Info.Timestamp)
+	if err != nil {
+		log.Error().Err(err).Str("poll", pollID).Msg("Could not store poll vote")
+	}
+	return true
+}
+
+// Counts the stored votes of a poll
+func getPollTally(db *sql.DB, userID int, id string) (*pollTally, error) {
+	var chat string
+	var raw []byte
+	err := db.QueryRow("SELECT chat,raw FROM messages WHERE user_id=? AND id=? AND type='poll' LIMIT 1", userID, id).Scan(&chat, &raw)
+	if err != nil {
+		return nil, err
+	}
+	msg := &waProto.Message{}
+	err = proto.Unmarshal(raw, msg)
+	if err != nil {
+		return nil, err
+	}
+	poll := pollCreation(msg)
+
+	tally := &pollTally{Id: id, Chat: chat, Name: poll.GetName(), SelectableCount: poll.GetSelectableOptionsCount(), Options: []pollOption{}}
+	index := make(map[string]int)
+	for _, option := range poll.GetOptions() {
+		index[option.GetOptionName()] = len(tally.Options)
+		tally.Options = append(tally.Options, pollOption{Name: option.GetOptionName(), Voters: []string{}})
+	}
+
+	rows, err := db.Query("SELECT voter,options FROM poll_votes WHERE user_id=? AND chat=? AND poll_id=? ORDER BY timestamp", userID, chat, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var voter, encoded string
+		err = rows.Scan(&voter, &encoded)
+		if err != nil {
+			return nil, err
+		}
+		var options []string
+		err = json.Unmarshal([]byte(encoded), &options)
+		if err != nil {
+			return nil, err
+		}
+		// An empty vote means the voter removed their vote
+		if len(options) == 0 {
+			continue
+		}
+		tally.Voters++
+		for _, name := range options {
+			i, ok := index[name]
+			if !ok {
+				continue
+			}
+			tally.Options[i].Votes++
+			tally.Options[i].Voters = append(tally.Options[i].Voters, voter)
+		}
+	}
+	return tally, rows.Err()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestPollCreation(t *testing.T) {
+	poll := &waProto.PollCreationMessage{Name: proto.String("Lunch?")}
+	tests := []struct {
+		name string
+		msg  *waProto.Message
+		want *waProto.PollCreationMessage
+	}{
+		{"v1", &waProto.Message{PollCreationMessage: poll}, poll},
+		{"v2", &waProto.Message{PollCreationMessageV2: poll}, poll},
+		{"v3", &waProto.Message{PollCreationMessageV3: poll}, poll},
+		{"not a poll", &waProto.Message{Conversation: proto.String("Lunch?")}, nil},
+		{"nil message", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pollCreation(tt.msg); got != tt.want {
+				t.Errorf("pollCreation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPollOptionNames(t *testing.T) {
+	poll := &waProto.PollCreationMessage{
+		Name: proto.String("Lunch?"),
+		Options: []*waProto.PollCreationMessage_Option{
+			{OptionName: proto.String("Pizza")},
+			{OptionName: proto.String("Sushi")},
+			{OptionName: proto.String("Tacos")},
+		},
+	}
+	hashes := whatsmeow.HashPollOptions([]string{"Tacos", "Pizza", "Removed"})
+
+	tests := []struct {
+		name   string
+		hashes [][]byte
+		want   []string
+	}{
+		{"in vote order", hashes[:2], []string{"Tacos", "Pizza"}},
+		{"unknown option kept in hex", hashes[2:], []string{hex.EncodeToString(hashes[2])}},
+		{"vote cleared", [][]byte{}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pollOptionNames(poll, tt.hashes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pollOptionNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Delivery states for messages tracked in the outbox table
+const (
+	MessageQueued    = "queued"
+	MessageSent      = "sent"
+	MessageDelivered = "delivered"
+	MessageRead      = "read"
+	MessageFailed    = "failed"
+)
+
+const (
+	queuePollInterval = 5 * time.Second
+	queueBatchSize    = 20
+	queueBaseBackoff  = 5 * time.Second
+	queueMaxBackoff   = 10 * time.Minute
+)
+
+var errMessageExists = errors.New("a message with this id already exists")
+
+var (
+	queueWakeup   = make(map[int]chan bool)
+	queueWakeupMu sync.Mutex
+)
+
+// Returns the channel used to wake up the queue worker of a user
+func queueSignal(userID int) chan bool {
+	queueWakeupMu.Lock()
+	defer queueWakeupMu.Unlock()
+	ch, ok := queueWakeup[userID]
+	if !ok {
+		ch = make(chan bool, 1)
+		queueWakeup[userID] = ch
+	}
+	return ch
+}
+
+// Exponential backoff for the given number of failed attempts
+func queueBackoff(attempts int) time.Duration {
+	delay := queueBaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= queueMaxBackoff {
+			return queueMaxBackoff
+		}
+	}
+	return delay
+}
+
+// Stores a message in the outbox to be sent by the user queue worker
+func (s *server) enqueueMessage(userID int, recipient types.JID, msgid string, msg *waProto.Message) error {
+	var existing int
+	err := s.db.QueryRow("SELECT 1 FROM outbox WHERE user_id=? AND id=? LIMIT 1", userID, msgid).Scan(&existing)
+	if err == nil {
+		return errMessageExists
+	}
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("could not encode message: %v", err)
+	}
+	now := time.Now().Unix()
+	_, err = s.db.Exec(
+		"INSERT INTO outbox(id,user_id,recipient,payload,status,attempts,next_attempt,created_at,updated_at) VALUES(?,?,?,?,?,0,?,?,?)",
+		msgid, userID, recipient.String(), payload, MessageQueued, now, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("could not queue message: %v", err)
+	}
+
+	select {
+	case queueSignal(userID) <- true:
+	default:
+	}
+	return nil
+}
+
+// Records a message sent synchronously so its delivery status can be tracked.
+// The payload is not kept, the message itself is stored in the messages table.
+func (s *server) recordSentMessage(userID int, recipient types.JID, msgid string) {
+	now := time.Now().Unix()
+	_, err := s.db.Exec(
+		"INSERT OR REPLACE INTO outbox(id,user_id,recipient,payload,status,attempts,next_attempt,created_at,updated_at) VALUES(?,?,?,x'',?,1,0,?,?)",
+		msgid, userID, recipient.String(), MessageSent, now, now,
+	)
+	if err != nil {
+		log.Warn().Err(err).Str("id", msgid).Msg("Could not record sent message")
+	}
+}
+
+// Updates delivery status for messages acknowledged by receipts, never downgrading it
+func updateMessageStatus(db *sql.DB, userID int, ids []string, status string) {
+	sqlStmt := `UPDATE outbox SET status=?,updated_at=? WHERE user_id=? AND id=? AND status IN ('queued','sent')`
+	if status == MessageRead {
+		sqlStmt = `UPDATE outbox SET status=?,updated_at=? WHERE user_id=? AND id=? AND status IN ('queued','sent','delivered')`
+	}
+	for _, id := range ids {
+		_, err := db.Exec(sqlStmt, status, time.Now().Unix(), userID, id)
+		if err != nil {
+			log.Warn().Err(err).Str("id", id).Msg("Could not update message status")
+		}
+	}
+}
+
+// Removes the status of messages that left the queue before the given time
+func pruneOutbox(db *sql.DB, before time.Time) (int64, error) {
+	res, err := db.Exec("DELETE FROM outbox WHERE status<>? AND updated_at<?", MessageQueued, before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Periodically removes the status of sent, delivered, read and failed messages older than the retention
+func (s *server) outboxJanitor() {
+	if *outboxRetention <= 0 {
+		return
+	}
+	for {
+		removed, err := pruneOutbox(s.db, time.Now().AddDate(0, 0, -*outboxRetention))
+		if err != nil {
+			log.Error().Err(err).Msg("Could not prune message status")
+		} else if removed > 0 {
+			log.Info().Int64("messages", removed).Msg("Message status retention applied")
+		}
+		time.Sleep(janitorInterval)
+	}
+}
+
+// Drains queued messages for a user until stopped
+func (s *server) queueWorker(userID int, client *whatsmeow.Client, stop chan bool) {
+	log.Info().Str("userid", strconv.Itoa(userID)).Msg("Starting message queue worker")
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+	wakeup := queueSignal(userID)
+	for {
+		select {
+		case <-stop:
+			log.Info().Str("userid", strconv.Itoa(userID)).Msg("Stopping message queue worker")
+			return
+		case <-wakeup:
+		case <-ticker.C:
+		}
+		if !client.IsConnected() || !client.IsLoggedIn() {
+			continue
+		}
+		s.drainQueue(userID, client)
+	}
+}
+
+type queuedMessage struct {
+	id        string
+	recipient string
+	payload   []byte
+	attempts  int
+}
+
+func (s *server) drainQueue(userID int, client *whatsmeow.Client) {
+	rows, err := s.db.Query(
+		"SELECT id,recipient,payload,attempts FROM outbox WHERE user_id=? AND status=? AND next_attempt<=? ORDER BY created_at LIMIT ?",
+		userID, MessageQueued, time.Now().Unix(), queueBatchSize,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("Could not read message queue")
+		return
+	}
+	var pending []queuedMessage
+	for rows.Next() {
+		var q queuedMessage
+		if err = rows.Scan(&q.id, &q.recipient, &q.payload, &q.attempts); err != nil {
+			log.Error().Err(err).Msg("Could not read message queue")
+			break
+		}
+		pending = append(pending, q)
+	}
+	rows.Close()
+
+	for _, q := range pending {
+		err := s.sendQueuedMessage(userID, client, q)
+		now := time.Now().Unix()
+		if err == nil {
+			log.Info().Str("id", q.id).Str("recipient", q.recipient).Msg("Queued message sent")
+			_, err = s.db.Exec(
+				"UPDATE outbox SET status=?,attempts=?,last_error='',payload=x'',updated_at=? WHERE user_id=? AND id=?",
+				MessageSent, q.attempts+1, now, userID, q.id,
+			)
+			if err != nil {
+				log.Error().Err(err).Str("id", q.id).Msg("Could not update message queue")
+			}
+			continue
+		}
+
+		attempts := q.attempts + 1
+		status := MessageQueued
+		payload := q.payload
+		if attempts >= *queueMaxAttempts {
+			status = MessageFailed
+			payload = []byte{}
+			log.Error().Err(err).Str("id", q.id).Int("attempts", attempts).Msg("Queued message failed permanently")
+		} else {
+			log.Warn().Err(err).Str("id", q.id).Int("attempts", attempts).Msg("Queued message failed, will retry")
+		}
+		_, err = s.db.Exec(
+			"UPDATE outbox SET status=?,attempts=?,next_attempt=?,last_error=?,payload=?,updated_at=? WHERE user_id=? AND id=?",
+			status, attempts, now+int64(queueBackoff(attempts).Seconds()), err.Error(), payload, now, userID, q.id,
+		)
+		if err != nil {
+			log.Error().Err(err).Str("id", q.id).Msg("Could not update message queue")
+		}
+	}
+}
+
+func (s *server) sendQueuedMessage(userID int, client *whatsmeow.Client, q queuedMessage) error {
+	if client == nil {
+		return errors.New("no session")
+	}
+	recipient, err := types.ParseJID(q.recipient)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %v", err)
+	}
+	var msg waProto.Message
+	if err = proto.Unmarshal(q.payload, &msg); err != nil {
+		return fmt.Errorf("invalid payload: %v", err)
+	}
+	resp, err := client.SendMessage(context.Background(), recipient, &msg, whatsmeow.SendRequestExtra{ID: q.id})
+	if err != nil {
+		return err
+	}
+	storeSentMessage(s.db, userID, client, recipient, q.id, &msg, resp.Timestamp)
+	return nil
+}
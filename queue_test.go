@@ -0,0 +1,97 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestQueueBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 5 * time.Second},
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{7, 320 * time.Second},
+		{8, queueMaxBackoff},
+		{100, queueMaxBackoff},
+	}
+	for _, tt := range tests {
+		if got := queueBackoff(tt.attempts); got != tt.want {
+			t.Errorf("queueBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE outbox (id TEXT NOT NULL, user_id INTEGER NOT NULL, recipient TEXT NOT NULL, payload BLOB NOT NULL, status TEXT NOT NULL, attempts INTEGER NOT NULL default 0, next_attempt INTEGER NOT NULL default 0, last_error TEXT NOT NULL default "", created_at INTEGER NOT NULL, updated_at INTEGER NOT NULL, PRIMARY KEY (user_id, id));`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{db: db}
+	recipient := types.NewJID("5491155554444", types.DefaultUserServer)
+	msg := &waProto.Message{Conversation: proto.String("Hellow Meow")}
+
+	if err = s.enqueueMessage(1, recipient, "3EB0A1", msg); err != nil {
+		t.Fatalf("enqueueMessage() error = %v", err)
+	}
+	if err = s.enqueueMessage(1, recipient, "3EB0A1", msg); err != errMessageExists {
+		t.Errorf("enqueueMessage() of a queued id error = %v, want %v", err, errMessageExists)
+	}
+	if err = s.enqueueMessage(2, recipient, "3EB0A1", msg); err != nil {
+		t.Errorf("enqueueMessage() of an id queued by another user error = %v", err)
+	}
+	s.recordSentMessage(1, recipient, "3EB0A2")
+	if err = s.enqueueMessage(1, recipient, "3EB0A2", msg); err != errMessageExists {
+		t.Errorf("enqueueMessage() of a sent id error = %v, want %v", err, errMessageExists)
+	}
+
+	var payload []byte
+	var status string
+	err = db.QueryRow("SELECT payload,status FROM outbox WHERE user_id=1 AND id='3EB0A2'").Scan(&payload, &status)
+	if err != nil || len(payload) != 0 || status != MessageSent {
+		t.Errorf("sent message payload %q, status %q, %v, want no payload and %q", payload, status, err, MessageSent)
+	}
+
+	old := time.Now().AddDate(0, 0, -10).Unix()
+	_, err = db.Exec("UPDATE outbox SET updated_at=?", old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO outbox(id,user_id,recipient,payload,status,created_at,updated_at) VALUES ('3EB0A3',1,'',x'','read',?,?),('3EB0A4',1,'',x'','failed',?,?),('3EB0A5',1,'',x'','delivered',0,?)`,
+		old, old, old, old, time.Now().Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := pruneOutbox(db, time.Now().AddDate(0, 0, -7))
+	if err != nil || removed != 3 {
+		t.Errorf("pruneOutbox() = %d, %v, want 3 old messages no longer queued", removed, err)
+	}
+	rows, err := db.Query("SELECT id FROM outbox ORDER BY user_id,id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var kept []string
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		kept = append(kept, id)
+	}
+	if want := []string{"3EB0A1", "3EB0A5", "3EB0A1"}; len(kept) != len(want) || kept[0] != want[0] || kept[1] != want[1] || kept[2] != want[2] {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+}
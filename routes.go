@@ -54,6 +54,31 @@ func (s *server) routes() {
 	c = c.Append(hlog.RefererHandler("referer"))
 	c = c.Append(hlog.RequestIDHandler("req_id", "Request-Id"))
 
+	a := alice.New()
+	a = a.Append(s.authadmin)
+	a = a.Append(hlog.NewHandler(log))
+	a = a.Append(
+		hlog.AccessHandler(func(r *http.Request, status, size int, duration time.Duration) {
+			hlog.FromRequest(r).Info().
+				Str("method", r.Method).
+				Stringer("url", r.URL).
+				Int("status", status).
+				Int("size", size).
+				Dur("duration", duration).
+				Msg("Got Admin API Request")
+		}),
+	)
+	a = a.Append(hlog.RemoteAddrHandler("ip"))
+	a = a.Append(hlog.UserAgentHandler("user_agent"))
+	a = a.Append(hlog.RequestIDHandler("req_id", "Request-Id"))
+
+	s.router.Handle("/admin/users", a.Then(s.ListUsers())).Methods("GET")
+	s.router.Handle("/admin/users", a.Then(s.CreateUser())).Methods("POST")
+	s.router.Handle("/admin/users/{id:[0-9]+}", a.Then(s.GetAdminUser())).Methods("GET")
+	s.router.Handle("/admin/users/{id:[0-9]+}", a.Then(s.UpdateUser())).Methods("PUT")
+	s.router.Handle("/admin/users/{id:[0-9]+}", a.Then(s.DeleteUser())).Methods("DELETE")
+	s.router.Handle("/admin/usage", a.Then(s.ListMediaUsage())).Methods("GET")
+
 	s.router.Handle("/session/connect", c.Then(s.Connect())).Methods("POST")
 	s.router.Handle("/session/disconnect", c.Then(s.Disconnect())).Methods("POST")
 	s.router.Handle("/session/logout", c.Then(s.Logout())).Methods("POST")
@@ -62,7 +87,18 @@ func (s *server) routes() {
 
 	s.router.Handle("/webhook", c.Then(s.SetWebhook())).Methods("POST")
 	s.router.Handle("/webhook", c.Then(s.GetWebhook())).Methods("GET")
+	s.router.Handle("/webhook/endpoints", c.Then(s.ListWebhookEndpoints())).Methods("GET")
+	s.router.Handle("/webhook/endpoints", c.Then(s.AddWebhookEndpoint())).Methods("POST")
+	s.router.Handle("/webhook/endpoints/{id:[0-9]+}", c.Then(s.GetWebhookEndpoint())).Methods("GET")
+	s.router.Handle("/webhook/endpoints/{id:[0-9]+}", c.Then(s.UpdateWebhookEndpoint())).Methods("PUT")
+	s.router.Handle("/webhook/endpoints/{id:[0-9]+}", c.Then(s.DeleteWebhookEndpoint())).Methods("DELETE")
+	s.router.Handle("/webhook/failed", c.Then(s.ListFailedWebhooks())).Methods("GET")
+	s.router.Handle("/webhook/failed/replay", c.Then(s.ReplayWebhooks())).Methods("POST")
+	s.router.Handle("/webhook/failed/{id:[0-9]+}", c.Then(s.DeleteFailedWebhook())).Methods("DELETE")
+
+	s.router.Handle("/events/stream", c.Then(s.StreamEvents())).Methods("GET")
 
+	s.router.Handle("/chat/send", c.Then(s.SendAny())).Methods("POST")
 	s.router.Handle("/chat/send/text", c.Then(s.SendMessage())).Methods("POST")
 	s.router.Handle("/chat/send/image", c.Then(s.SendImage())).Methods("POST")
 	s.router.Handle("/chat/send/audio", c.Then(s.SendAudio())).Methods("POST")
@@ -73,8 +109,15 @@ func (s *server) routes() {
 	s.router.Handle("/chat/send/location", c.Then(s.SendLocation())).Methods("POST")
 	s.router.Handle("/chat/send/contact", c.Then(s.SendContact())).Methods("POST")
 	s.router.Handle("/chat/react", c.Then(s.React())).Methods("POST")
+	s.router.Handle("/chat/edit", c.Then(s.EditMessage())).Methods("POST")
+	s.router.Handle("/chat/revoke", c.Then(s.RevokeMessage())).Methods("POST")
 	s.router.Handle("/chat/send/buttons", c.Then(s.SendButtons())).Methods("POST")
 	s.router.Handle("/chat/send/list", c.Then(s.SendList())).Methods("POST")
+	s.router.Handle("/chat/send/poll", c.Then(s.SendPoll())).Methods("POST")
+	s.router.Handle("/chat/poll/{id}", c.Then(s.GetPoll())).Methods("GET")
+	s.router.Handle("/chat/messages/{id}", c.Then(s.GetMessageStatus())).Methods("GET")
+	s.router.Handle("/chat/history", c.Then(s.GetHistory())).Methods("GET")
+	s.router.Handle("/chat/list", c.Then(s.ListChats())).Methods("GET")
 
 	s.router.Handle("/user/info", c.Then(s.GetUser())).Methods("POST")
 	s.router.Handle("/user/check", c.Then(s.CheckUser())).Methods("POST")
@@ -87,12 +130,32 @@ func (s *server) routes() {
 	s.router.Handle("/chat/downloadvideo", c.Then(s.DownloadVideo())).Methods("POST")
 	s.router.Handle("/chat/downloadaudio", c.Then(s.DownloadAudio())).Methods("POST")
 	s.router.Handle("/chat/downloaddocument", c.Then(s.DownloadDocument())).Methods("POST")
+	s.router.Handle("/media/settings", c.Then(s.GetMediaSettings())).Methods("GET")
+	s.router.Handle("/media/settings", c.Then(s.SetMediaSettings())).Methods("POST")
+	s.router.Handle("/call/settings", c.Then(s.GetCallSettings())).Methods("GET")
+	s.router.Handle("/call/settings", c.Then(s.SetCallSettings())).Methods("POST")
+	s.router.Handle("/media/usage", c.Then(s.GetMediaUsage())).Methods("GET")
+	s.router.Handle("/media/{id}", c.Then(s.GetMedia())).Methods("GET")
 
 	s.router.Handle("/group/list", c.Then(s.ListGroups())).Methods("GET")
 	s.router.Handle("/group/info", c.Then(s.GetGroupInfo())).Methods("GET")
 	s.router.Handle("/group/invitelink", c.Then(s.GetGroupInviteLink())).Methods("GET")
 	s.router.Handle("/group/photo", c.Then(s.SetGroupPhoto())).Methods("POST")
 	s.router.Handle("/group/name", c.Then(s.SetGroupName())).Methods("POST")
+	s.router.Handle("/group/create", c.Then(s.CreateGroup())).Methods("POST")
+	s.router.Handle("/group/participants", c.Then(s.UpdateGroupParticipants())).Methods("POST")
+	s.router.Handle("/group/topic", c.Then(s.SetGroupTopic())).Methods("POST")
+	s.router.Handle("/group/settings", c.Then(s.SetGroupSettings())).Methods("POST")
+	s.router.Handle("/group/leave", c.Then(s.LeaveGroup())).Methods("POST")
+	s.router.Handle("/group/join", c.Then(s.JoinGroup())).Methods("POST")
+	s.router.Handle("/group/inviteinfo", c.Then(s.GetGroupInviteInfo())).Methods("POST")
+	s.router.Handle("/group/requests", c.Then(s.GetGroupJoinRequests())).Methods("GET")
+	s.router.Handle("/group/requests", c.Then(s.UpdateGroupJoinRequests())).Methods("POST")
+
+	s.router.Handle("/community/list", c.Then(s.ListCommunities())).Methods("GET")
+	s.router.Handle("/community/create", c.Then(s.CreateCommunity())).Methods("POST")
+	s.router.Handle("/community/link", c.Then(s.LinkCommunityGroup())).Methods("POST")
+	s.router.Handle("/community/unlink", c.Then(s.UnlinkCommunityGroup())).Methods("POST")
 
 	s.router.PathPrefix("/").Handler(http.FileServer(http.Dir(exPath + "/static/")))
 }
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Message types accepted by /chat/send
+var sendTypes = []string{"text", "image", "audio", "video", "document", "sticker", "location", "contact", "buttons", "list", "poll"}
+
+const maxPollOptions = 12
+
+// Quoted message of a reply
+type sendQuoted struct {
+	Id          string
+	Participant string
+}
+
+type sendButton struct {
+	ButtonId   string
+	ButtonText string
+}
+
+type sendListRow struct {
+	RowId       string
+	Title       string
+	Description string
+}
+
+type sendListSection struct {
+	Title string
+	Rows  []sendListRow
+}
+
+// Payload of /chat/send, Type selects the message kind and which content fields are used.
+// The legacy /chat/send/* routes decode the same payload with a fixed Type.
+type sendRequest struct {
+	Type  string
+	Phone string
+	Id    string
+	Queue bool
+
+	// Options common to all types
+	Quoted      *sendQuoted
+	Mentions    []string
+	MentionAll  bool
+	Ephemeral   uint32
+	ContextInfo waProto.ContextInfo
+
+	// text
+	Body string
+	// image, audio, video, document and sticker, as a data URL, an http(s) URL or a multipart upload
+	Image    string
+	Audio    string
+	Video    string
+	Document string
+	Sticker  string
+	Caption  string
+	FileName string
+
+	JpegThumbnail []byte
+	PngThumbnail  []byte
+
+	// location, contact and poll
+	Name      string
+	Latitude  float64
+	Longitude float64
+	Vcard     string
+
+	// buttons and list
+	Title       string
+	Buttons     []sendButton
+	Description string
+	ButtonText  string
+	FooterText  string
+	Sections    []sendListSection
+
+	// poll
+	Options         []string
+	SelectableCount int
+}
+
+// Content field of media types
+func (t *sendRequest) media() string {
+	switch t.Type {
+	case "image":
+		return t.Image
+	case "audio":
+		return t.Audio
+	case "video":
+		return t.Video
+	case "document":
+		return t.Document
+	case "sticker":
+		return t.Sticker
+	}
+	return ""
+}
+
+func isMediaType(msgType string) bool {
+	return Find([]string{"image", "audio", "video", "document", "sticker"}, msgType)
+}
+
+// Checks the fields required by the message type
+func (t *sendRequest) validate(upload *mediaFile) error {
+	if t.Type == "" {
+		return errors.New("missing type in payload")
+	}
+	if !Find(sendTypes, t.Type) {
+		return fmt.Errorf("invalid type %q, must be one of %s", t.Type, strings.Join(sendTypes, ", "))
+	}
+	if t.Phone == "" {
+		return errors.New("missing phone in payload")
+	}
+	if isMediaType(t.Type) && t.media() == "" && upload == nil {
+		return fmt.Errorf("missing %s in payload", t.Type)
+	}
+
+	switch t.Type {
+	case "text":
+		if t.Body == "" {
+			return errors.New("missing body in payload")
+		}
+	case "location":
+		if t.Latitude == 0 {
+			return errors.New("missing latitude in payload")
+		}
+		if t.Longitude == 0 {
+			return errors.New("missing longitude in payload")
+		}
+	case "contact":
+		if t.Name == "" {
+			return errors.New("missing name in payload")
+		}
+		if t.Vcard == "" {
+			return errors.New("missing vcard in payload")
+		}
+	case "buttons":
+		if t.Title == "" {
+			return errors.New("missing title in payload")
+		}
+		if len(t.Buttons) < 1 {
+			return errors.New("missing buttons in payload")
+		}
+		if len(t.Buttons) > 3 {
+			return errors.New("buttons cant more than 3")
+		}
+	case "list":
+		if t.Title == "" {
+			return errors.New("missing title in payload")
+		}
+		if t.Description == "" {
+			return errors.New("missing description in payload")
+		}
+		if t.ButtonText == "" {
+			return errors.New("missing buttontext in payload")
+		}
+		if len(t.Sections) < 1 {
+			return errors.New("missing sections in payload")
+		}
+	case "poll":
+		if t.Name == "" {
+			return errors.New("missing name in payload")
+		}
+		if len(t.Options) < 2 || len(t.Options) > maxPollOptions {
+			return fmt.Errorf("polls need between 2 and %d options", maxPollOptions)
+		}
+		if t.SelectableCount < 0 || t.SelectableCount > len(t.Options) {
+			return errors.New("selectablecount must be between 0 (any number) and the number of options")
+		}
+	}
+
+	// Legacy payloads pass the quoted message in ContextInfo
+	if t.Quoted == nil && (t.ContextInfo.StanzaId != nil || t.ContextInfo.Participant != nil) {
+		t.Quoted = &sendQuoted{Id: t.ContextInfo.GetStanzaId(), Participant: t.ContextInfo.GetParticipant()}
+	}
+	if t.Quoted != nil && t.Quoted.Id == "" {
+		return errors.New("missing stanzaid in contextinfo")
+	}
+	return nil
+}
+
+// Builds the context info for the common options, nil if none is set. On error the HTTP status to respond with is returned.
+func (t *sendRequest) contextInfo(db *sql.DB, userID int, client *whatsmeow.Client, recipient types.JID) (*waProto.ContextInfo, int, error) {
+	if t.Quoted == nil && len(t.Mentions) == 0 && !t.MentionAll && t.Ephemeral == 0 {
+		return nil, 0, nil
+	}
+	ci := &waProto.ContextInfo{}
+	if t.Quoted != nil {
+		// The quoted content is taken from the message store so the quote renders on the recipient phone
+		quoted, sender, err := getQuotedMessage(db, userID, recipient, t.Quoted.Id)
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("could not get quoted message: %v", err)
+		}
+		participant := t.Quoted.Participant
+		ci.StanzaId = proto.String(t.Quoted.Id)
+		// When neither the stored message nor its sender are known only the stanza id is sent
+		switch {
+		case quoted != nil:
+			if participant == "" {
+				participant = sender
+			}
+			ci.Participant = proto.String(participant)
+			ci.QuotedMessage = stripContextInfo(quoted)
+		case participant != "":
+			ci.Participant = proto.String(participant)
+			ci.QuotedMessage = &waProto.Message{Conversation: proto.String("")}
+		}
+	}
+
+	mentions := make(map[string]bool)
+	for _, mention := range t.Mentions {
+		jid, ok := parseJID(mention)
+		if !ok {
+			return nil, http.StatusBadRequest, fmt.Errorf("could not parse mention %s", mention)
+		}
+		mentions[jid.ToNonAD().String()] = true
+	}
+	if t.MentionAll {
+		if recipient.Server != types.GroupServer {
+			return nil, http.StatusBadRequest, errors.New("mentionall can only be used in groups")
+		}
+		info, err := client.GetGroupInfo(recipient)
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("could not get group participants: %v", err)
+		}
+		for _, jid := range groupMentions(info.Participants, client.Store.ID) {
+			mentions[jid] = true
+		}
+	}
+	for jid := range mentions {
+		ci.MentionedJid = append(ci.MentionedJid, jid)
+	}
+	sort.Strings(ci.MentionedJid)
+
+	if t.Ephemeral > 0 {
+		ci.Expiration = proto.Uint32(t.Ephemeral)
+	}
+	return ci, 0, nil
+}
+
+// Jids mentioned by MentionAll, every participant of the group but the sender
+func groupMentions(participants []types.GroupParticipant, self *types.JID) []string {
+	var jids []string
+	for _, participant := range participants {
+		if self == nil || participant.JID.User != self.User {
+			jids = append(jids, participant.JID.ToNonAD().String())
+		}
+	}
+	return jids
+}
+
+// Builds the new content of an edited message the way /chat/send builds a text message.
+// The quote of the original message is kept, and so are its mentions unless the edit sets its own.
+func editContent(db *sql.DB, userID int, client *whatsmeow.Client, recipient types.JID, id string, t *sendRequest) (*waProto.Message, int, error) {
+	ci, status, err := t.contextInfo(db, userID, client, recipient)
+	if err != nil {
+		return nil, status, err
+	}
+	original, _, err := getQuotedMessage(db, userID, recipient, id)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("could not get edited message: %v", err)
+	}
+	_, _, originalCI := messageContent(original)
+	if originalCI != nil {
+		if ci == nil {
+			ci = &waProto.ContextInfo{MentionedJid: originalCI.MentionedJid, Expiration: originalCI.Expiration}
+		}
+		ci.StanzaId = originalCI.StanzaId
+		ci.Participant = originalCI.Participant
+		ci.QuotedMessage = originalCI.QuotedMessage
+	}
+
+	msg := &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{
+		Text: proto.String(t.Body),
+	}}
+	if ci != nil {
+		setContextInfo(msg, ci)
+	}
+	return msg, 0, nil
+}
+
+// Copies a message without its own context info, quotes do not nest
+func stripContextInfo(msg *waProto.Message) *waProto.Message {
+	msg = proto.Clone(msg).(*waProto.Message)
+	msg.MessageContextInfo = nil
+	setContextInfo(msg, nil)
+	return msg
+}
+
+// Sets the context info of a message, whatever its type
+func setContextInfo(msg *waProto.Message, ci *waProto.ContextInfo) {
+	switch {
+	case msg.ExtendedTextMessage != nil:
+		msg.ExtendedTextMessage.ContextInfo = ci
+	case msg.ImageMessage != nil:
+		msg.ImageMessage.ContextInfo = ci
+	case msg.AudioMessage != nil:
+		msg.AudioMessage.ContextInfo = ci
+	case msg.VideoMessage != nil:
+		msg.VideoMessage.ContextInfo = ci
+	case msg.DocumentMessage != nil:
+		msg.DocumentMessage.ContextInfo = ci
+	case msg.StickerMessage != nil:
+		msg.StickerMessage.ContextInfo = ci
+	case msg.LocationMessage != nil:
+		msg.LocationMessage.ContextInfo = ci
+	case msg.ContactMessage != nil:
+		msg.ContactMessage.ContextInfo = ci
+	case msg.PollCreationMessage != nil:
+		msg.PollCreationMessage.ContextInfo = ci
+	case msg.ViewOnceMessage != nil:
+		inner := msg.ViewOnceMessage.GetMessage()
+		switch {
+		case inner.GetButtonsMessage() != nil:
+			inner.ButtonsMessage.ContextInfo = ci
+		case inner.GetListMessage() != nil:
+			inner.ListMessage.ContextInfo = ci
+		}
+	}
+}
+
+// Sends a message of any type, see sendRequest
+func (s *server) SendAny() http.HandlerFunc {
+	return s.sendHandler("")
+}
+
+// Handles /chat/send, or one of the legacy routes when msgType is set
+func (s *server) sendHandler(msgType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		var t sendRequest
+		upload, err := decodeSendRequest(w, r, &t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if msgType != "" {
+			t.Type = msgType
+		}
+		t.Type = strings.ToLower(t.Type)
+
+		err = t.validate(upload)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		recipient, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+			return
+		}
+		ci, status, err := t.contextInfo(s.db, userid, clientPointer[userid], recipient)
+		if err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
+
+		msgid := t.Id
+		if msgid == "" {
+			msgid = whatsmeow.GenerateMessageID()
+		}
+
+		msg, status, err := s.buildMessage(clientPointer[userid], &t, upload)
+		if err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
+		if ci != nil {
+			setContextInfo(msg, ci)
+		}
+
+		s.sendAndRespond(w, r, userid, recipient, msgid, msg, t.Queue)
+	}
+}
+
+// Builds the message for a validated request, uploading its media. On error the HTTP status to respond with is returned.
+func (s *server) buildMessage(client *whatsmeow.Client, t *sendRequest, upload *mediaFile) (*waProto.Message, int, error) {
+	switch t.Type {
+	case "text":
+		return &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text: proto.String(t.Body),
+		}}, 0, nil
+	case "location":
+		return &waProto.Message{LocationMessage: &waProto.LocationMessage{
+			DegreesLatitude:  proto.Float64(t.Latitude),
+			DegreesLongitude: proto.Float64(t.Longitude),
+			Name:             proto.String(t.Name),
+		}}, 0, nil
+	case "contact":
+		return &waProto.Message{ContactMessage: &waProto.ContactMessage{
+			DisplayName: proto.String(t.Name),
+			Vcard:       proto.String(t.Vcard),
+		}}, 0, nil
+	case "buttons":
+		return buildButtonsMessage(t), 0, nil
+	case "list":
+		return buildListMessage(t), 0, nil
+	case "poll":
+		return client.BuildPollCreation(t.Name, t.Options, t.SelectableCount), 0, nil
+	}
+
+	media, err := getMediaFile(upload, t.media())
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	switch t.Type {
+	case "image":
+		return buildImageMessage(client, t, media)
+	case "audio":
+		return buildAudioMessage(client, media)
+	case "video":
+		return buildVideoMessage(client, t, media)
+	case "document":
+		return buildDocumentMessage(client, t, media)
+	case "sticker":
+		return buildStickerMessage(client, t, media)
+	}
+	return nil, http.StatusBadRequest, fmt.Errorf("invalid type %q", t.Type)
+}
+
+func uploadMedia(client *whatsmeow.Client, data []byte, mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, int, error) {
+	uploaded, err := client.Upload(context.Background(), data, mediaType)
+	if err != nil {
+		return uploaded, http.StatusInternalServerError, fmt.Errorf("failed to upload file: %v", err)
+	}
+	return uploaded, 0, nil
+}
+
+// Thumbnail and dimensions of an image or video, nil when they cannot be generated
+func previewFor(data []byte, kind string) *mediaPreview {
+	preview, err := converter.Preview(data)
+	if err != nil {
+		if err != errNoConverter {
+			log.Warn().Err(err).Msg("Could not generate " + kind + " thumbnail")
+		}
+		return nil
+	}
+	return preview
+}
+
+// Voice note for an audio file, converted to ogg/opus unless it already is. Without a converter only ogg/opus is accepted.
+func voiceFor(media *mediaFile) (*voiceNote, int, error) {
+	voice, err := converter.Voice(media.Data, media.Mimetype)
+	if err == errNoConverter {
+		if !isOggOpus(media.Data) {
+			return nil, http.StatusBadRequest, fmt.Errorf("ogg/opus audio expected, got %s", media.Mimetype)
+		}
+		return &voiceNote{Data: media.Data}, 0, nil
+	} else if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	return voice, 0, nil
+}
+
+// Sticker data for an image, converted to webp unless it already is
+func stickerFor(media *mediaFile) ([]byte, int, error) {
+	if media.Mimetype == "image/webp" {
+		return media.Data, 0, nil
+	}
+	data, err := converter.Sticker(media.Data)
+	if err == errNoConverter {
+		return nil, http.StatusBadRequest, fmt.Errorf("webp sticker expected, got %s", media.Mimetype)
+	} else if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	return data, 0, nil
+}
+
+func buildImageMessage(client *whatsmeow.Client, t *sendRequest, media *mediaFile) (*waProto.Message, int, error) {
+	if !strings.HasPrefix(media.Mimetype, "image/") {
+		return nil, http.StatusBadRequest, fmt.Errorf("image expected, got %s", media.Mimetype)
+	}
+	uploaded, status, err := uploadMedia(client, media.Data, whatsmeow.MediaImage)
+	if err != nil {
+		return nil, status, err
+	}
+	msg := &waProto.Message{ImageMessage: &waProto.ImageMessage{
+		Caption:       proto.String(t.Caption),
+		Url:           proto.String(uploaded.URL),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		Mimetype:      proto.String(media.Mimetype),
+		FileEncSha256: uploaded.FileEncSHA256,
+		FileSha256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uint64(len(media.Data))),
+		JpegThumbnail: t.JpegThumbnail,
+	}}
+
+	if preview := previewFor(media.Data, "image"); preview != nil {
+		if len(t.JpegThumbnail) == 0 {
+			msg.ImageMessage.JpegThumbnail = preview.JpegThumbnail
+		}
+		msg.ImageMessage.Width = proto.Uint32(preview.Width)
+		msg.ImageMessage.Height = proto.Uint32(preview.Height)
+	}
+	return msg, 0, nil
+}
+
+func buildAudioMessage(client *whatsmeow.Client, media *mediaFile) (*waProto.Message, int, error) {
+	voice, status, err := voiceFor(media)
+	if err != nil {
+		return nil, status, err
+	}
+
+	uploaded, status, err := uploadMedia(client, voice.Data, whatsmeow.MediaAudio)
+	if err != nil {
+		return nil, status, err
+	}
+	msg := &waProto.Message{AudioMessage: &waProto.AudioMessage{
+		Url:           proto.String(uploaded.URL),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		Mimetype:      proto.String("audio/ogg; codecs=opus"),
+		FileEncSha256: uploaded.FileEncSHA256,
+		FileSha256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uint64(len(voice.Data))),
+		Ptt:           proto.Bool(true),
+		Waveform:      voice.Waveform,
+	}}
+	if voice.Seconds > 0 {
+		msg.AudioMessage.Seconds = proto.Uint32(voice.Seconds)
+	}
+	return msg, 0, nil
+}
+
+func buildVideoMessage(client *whatsmeow.Client, t *sendRequest, media *mediaFile) (*waProto.Message, int, error) {
+	uploaded, status, err := uploadMedia(client, media.Data, whatsmeow.MediaVideo)
+	if err != nil {
+		return nil, status, err
+	}
+	msg := &waProto.Message{VideoMessage: &waProto.VideoMessage{
+		Caption:       proto.String(t.Caption),
+		Url:           proto.String(uploaded.URL),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		Mimetype:      proto.String(media.Mimetype),
+		FileEncSha256: uploaded.FileEncSHA256,
+		FileSha256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uint64(len(media.Data))),
+		JpegThumbnail: t.JpegThumbnail,
+	}}
+
+	if preview := previewFor(media.Data, "video"); preview != nil {
+		if len(t.JpegThumbnail) == 0 {
+			msg.VideoMessage.JpegThumbnail = preview.JpegThumbnail
+		}
+		msg.VideoMessage.Width = proto.Uint32(preview.Width)
+		msg.VideoMessage.Height = proto.Uint32(preview.Height)
+		msg.VideoMessage.Seconds = proto.Uint32(preview.Seconds)
+	}
+	return msg, 0, nil
+}
+
+func buildDocumentMessage(client *whatsmeow.Client, t *sendRequest, media *mediaFile) (*waProto.Message, int, error) {
+	fileName := t.FileName
+	if fileName == "" {
+		fileName = media.FileName
+	}
+	if fileName == "" {
+		return nil, http.StatusBadRequest, errors.New("missing filename in payload")
+	}
+	uploaded, status, err := uploadMedia(client, media.Data, whatsmeow.MediaDocument)
+	if err != nil {
+		return nil, status, err
+	}
+	msg := &waProto.Message{DocumentMessage: &waProto.DocumentMessage{
+		Url:           proto.String(uploaded.URL),
+		FileName:      proto.String(fileName),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		Mimetype:      proto.String(media.Mimetype),
+		FileEncSha256: uploaded.FileEncSHA256,
+		FileSha256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uint64(len(media.Data))),
+	}}
+	if t.Caption != "" {
+		msg.DocumentMessage.Caption = proto.String(t.Caption)
+	}
+	return msg, 0, nil
+}
+
+func buildStickerMessage(client *whatsmeow.Client, t *sendRequest, media *mediaFile) (*waProto.Message, int, error) {
+	data, status, err := stickerFor(media)
+	if err != nil {
+		return nil, status, err
+	}
+	uploaded, status, err := uploadMedia(client, data, whatsmeow.MediaImage)
+	if err != nil {
+		return nil, status, err
+	}
+	return &waProto.Message{StickerMessage: &waProto.StickerMessage{
+		Url:           proto.String(uploaded.URL),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		Mimetype:      proto.String("image/webp"),
+		FileEncSha256: uploaded.FileEncSHA256,
+		FileSha256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uint64(len(data))),
+		PngThumbnail:  t.PngThumbnail,
+	}}, 0, nil
+}
+
+// Buttons (not supported by current WhatsApp clients, may not be displayed)
+func buildButtonsMessage(t *sendRequest) *waProto.Message {
+	var buttons []*waProto.ButtonsMessage_Button
+	for _, item := range t.Buttons {
+		buttons = append(buttons, &waProto.ButtonsMessage_Button{
+			ButtonId: proto.String(item.ButtonId),
+			ButtonText: &waProto.ButtonsMessage_Button_ButtonText{
+				DisplayText: proto.String(item.ButtonText),
+			},
+			Type:           waProto.ButtonsMessage_Button_RESPONSE.Enum(),
+			NativeFlowInfo: &waProto.ButtonsMessage_Button_NativeFlowInfo{},
+		})
+	}
+	return &waProto.Message{ViewOnceMessage: &waProto.FutureProofMessage{
+		Message: &waProto.Message{
+			ButtonsMessage: &waProto.ButtonsMessage{
+				ContentText: proto.String(t.Title),
+				HeaderType:  waProto.ButtonsMessage_EMPTY.Enum(),
+				Buttons:     buttons,
+			},
+		},
+	}}
+}
+
+// https://github.com/tulir/whatsmeow/issues/305
+func buildListMessage(t *sendRequest) *waProto.Message {
+	var sections []*waProto.ListMessage_Section
+	for _, item := range t.Sections {
+		var rows []*waProto.ListMessage_Row
+		for i, row := range item.Rows {
+			rowID := row.RowId
+			if rowID == "" {
+				rowID = strconv.Itoa(i + 1)
+			}
+			rows = append(rows, &waProto.ListMessage_Row{
+				RowId:       proto.String(rowID),
+				Title:       proto.String(row.Title),
+				Description: proto.String(row.Description),
+			})
+		}
+		sections = append(sections, &waProto.ListMessage_Section{
+			Title: proto.String(item.Title),
+			Rows:  rows,
+		})
+	}
+	return &waProto.Message{ViewOnceMessage: &waProto.FutureProofMessage{
+		Message: &waProto.Message{
+			ListMessage: &waProto.ListMessage{
+				Title:       proto.String(t.Title),
+				Description: proto.String(t.Description),
+				ButtonText:  proto.String(t.ButtonText),
+				ListType:    waProto.ListMessage_SINGLE_SELECT.Enum(),
+				Sections:    sections,
+				FooterText:  proto.String(t.FooterText),
+			},
+		},
+	}}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"reflect"
+	"testing"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+	_ "modernc.org/sqlite"
+)
+
+// In-memory database with the messages table used to resolve quotes
+func newMessagesDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`CREATE TABLE messages (user_id INTEGER NOT NULL, id TEXT NOT NULL, chat TEXT NOT NULL, sender TEXT NOT NULL, text TEXT NOT NULL default "", raw BLOB)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSendRequestContextInfo(t *testing.T) {
+	db := newMessagesDB(t)
+	chat := types.NewJID("5491155554444", types.DefaultUserServer)
+	stored := &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{
+		Text:        proto.String("hello"),
+		ContextInfo: &waProto.ContextInfo{StanzaId: proto.String("OLDER")},
+	}}
+	raw, err := proto.Marshal(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("INSERT INTO messages(user_id,id,chat,sender,text,raw) VALUES(1,'STORED',?,'5491155554444@s.whatsapp.net','hello',?)", chat.String(), raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		req    *sendRequest
+		want   *waProto.ContextInfo
+		status int
+	}{
+		{
+			name: "no options",
+			req:  &sendRequest{},
+		},
+		{
+			name: "stored quote takes its sender and content",
+			req:  &sendRequest{Quoted: &sendQuoted{Id: "STORED"}},
+			want: &waProto.ContextInfo{
+				StanzaId:      proto.String("STORED"),
+				Participant:   proto.String("5491155554444@s.whatsapp.net"),
+				QuotedMessage: &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{Text: proto.String("hello")}},
+			},
+		},
+		{
+			name: "unknown quote with participant",
+			req:  &sendRequest{Quoted: &sendQuoted{Id: "UNKNOWN", Participant: "5491155553935@s.whatsapp.net"}},
+			want: &waProto.ContextInfo{
+				StanzaId:      proto.String("UNKNOWN"),
+				Participant:   proto.String("5491155553935@s.whatsapp.net"),
+				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
+			},
+		},
+		{
+			name: "unknown quote without participant keeps only the stanza id",
+			req:  &sendRequest{Quoted: &sendQuoted{Id: "UNKNOWN"}},
+			want: &waProto.ContextInfo{StanzaId: proto.String("UNKNOWN")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ci, status, err := tt.req.contextInfo(db, 1, nil, chat)
+			if status != tt.status {
+				t.Fatalf("contextInfo() status = %d, want %d (err %v)", status, tt.status, err)
+			}
+			if tt.status != 0 {
+				if err == nil {
+					t.Fatal("contextInfo() returned no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("contextInfo() error = %v", err)
+			}
+			if !proto.Equal(ci, tt.want) {
+				t.Errorf("contextInfo() = %v, want %v", ci, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendRequestContextInfoMentions(t *testing.T) {
+	db := newMessagesDB(t)
+	chat := types.NewJID("5491155554444", types.DefaultUserServer)
+	tests := []struct {
+		name   string
+		req    *sendRequest
+		want   []string
+		status int
+	}{
+		{"mentions are deduplicated and sorted", &sendRequest{Mentions: []string{"5491155553935", "5491155551111", "5491155553935@s.whatsapp.net"}}, []string{"5491155551111@s.whatsapp.net", "5491155553935@s.whatsapp.net"}, 0},
+		{"mention with a device", &sendRequest{Mentions: []string{"5491155553935.0:2@s.whatsapp.net"}}, []string{"5491155553935@s.whatsapp.net"}, 0},
+		{"invalid mention", &sendRequest{Mentions: []string{"not a phone"}}, nil, http.StatusBadRequest},
+		{"mentionall outside a group", &sendRequest{MentionAll: true}, nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ci, status, err := tt.req.contextInfo(db, 1, nil, chat)
+			if status != tt.status {
+				t.Fatalf("contextInfo() status = %d, want %d (err %v)", status, tt.status, err)
+			}
+			if tt.status != 0 {
+				if err == nil {
+					t.Fatal("contextInfo() returned no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("contextInfo() error = %v", err)
+			}
+			if !reflect.DeepEqual(ci.GetMentionedJid(), tt.want) {
+				t.Errorf("mentions = %v, want %v", ci.GetMentionedJid(), tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupMentions(t *testing.T) {
+	self := types.NewADJID("5491155550000", 0, 3)
+	participants := []types.GroupParticipant{
+		{JID: types.NewJID("5491155553935", types.DefaultUserServer)},
+		{JID: types.NewJID("5491155550000", types.DefaultUserServer), IsAdmin: true},
+		{JID: types.NewADJID("5491155551111", 0, 2)},
+	}
+	tests := []struct {
+		name string
+		self *types.JID
+		want []string
+	}{
+		{"sender is excluded whatever its device", &self, []string{"5491155553935@s.whatsapp.net", "5491155551111@s.whatsapp.net"}},
+		{"everyone without a session", nil, []string{"5491155553935@s.whatsapp.net", "5491155550000@s.whatsapp.net", "5491155551111@s.whatsapp.net"}},
+	}
+	for _, tt := range tests {
+		if got := groupMentions(participants, tt.self); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: groupMentions() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if got := groupMentions([]types.GroupParticipant{{JID: self.ToNonAD()}}, &self); len(got) != 0 {
+		t.Errorf("groupMentions() of a group with only the sender = %v, want none", got)
+	}
+}
+
+func TestSendRequestValidateLegacyContextInfo(t *testing.T) {
+	req := sendRequest{Type: "text", Phone: "5491155554444", Body: "hi", ContextInfo: waProto.ContextInfo{
+		StanzaId:    proto.String("AA3DSE28UDJES3"),
+		Participant: proto.String("5491155553935@s.whatsapp.net"),
+	}}
+	if err := req.validate(nil); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+	want := &sendQuoted{Id: "AA3DSE28UDJES3", Participant: "5491155553935@s.whatsapp.net"}
+	if !reflect.DeepEqual(req.Quoted, want) {
+		t.Errorf("Quoted = %+v, want %+v", req.Quoted, want)
+	}
+
+	req = sendRequest{Type: "text", Phone: "5491155554444", Body: "hi", ContextInfo: waProto.ContextInfo{
+		Participant: proto.String("5491155553935@s.whatsapp.net"),
+	}}
+	if err := req.validate(nil); err == nil {
+		t.Error("validate() accepted a quote without stanza id")
+	}
+}
+
+func TestEditContent(t *testing.T) {
+	db := newMessagesDB(t)
+	chat := types.NewJID("5491155554444", types.DefaultUserServer)
+	quote := &waProto.Message{Conversation: proto.String("question")}
+	original := &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{
+		Text: proto.String("Hi @5491155553935"),
+		ContextInfo: &waProto.ContextInfo{
+			StanzaId:      proto.String("QUESTION"),
+			Participant:   proto.String("5491155554444@s.whatsapp.net"),
+			QuotedMessage: quote,
+			MentionedJid:  []string{"5491155553935@s.whatsapp.net"},
+		},
+	}}
+	raw, err := proto.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("INSERT INTO messages(user_id,id,chat,sender,text,raw) VALUES(1,'REPLY',?,'me','Hi',?)", chat.String(), raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		req  *sendRequest
+		want *waProto.ContextInfo
+	}{
+		{
+			name: "quote and mentions of the original are kept",
+			id:   "REPLY",
+			req:  &sendRequest{Body: "Hello @5491155553935"},
+			want: original.ExtendedTextMessage.ContextInfo,
+		},
+		{
+			name: "new mentions replace the original ones",
+			id:   "REPLY",
+			req:  &sendRequest{Body: "Hello @5491155551111", Mentions: []string{"5491155551111"}},
+			want: &waProto.ContextInfo{
+				StanzaId:      proto.String("QUESTION"),
+				Participant:   proto.String("5491155554444@s.whatsapp.net"),
+				QuotedMessage: quote,
+				MentionedJid:  []string{"5491155551111@s.whatsapp.net"},
+			},
+		},
+		{
+			name: "unknown message with mentions",
+			id:   "UNKNOWN",
+			req:  &sendRequest{Body: "Hello @5491155551111", Mentions: []string{"5491155551111"}},
+			want: &waProto.ContextInfo{MentionedJid: []string{"5491155551111@s.whatsapp.net"}},
+		},
+		{
+			name: "unknown message without options",
+			id:   "UNKNOWN",
+			req:  &sendRequest{Body: "Hello"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, status, err := editContent(db, 1, nil, chat, tt.id, tt.req)
+			if err != nil {
+				t.Fatalf("editContent() status %d, error = %v", status, err)
+			}
+			if got := msg.GetExtendedTextMessage().GetText(); got != tt.req.Body {
+				t.Errorf("text = %q, want %q", got, tt.req.Body)
+			}
+			if got := msg.GetExtendedTextMessage().GetContextInfo(); !proto.Equal(got, tt.want) {
+				t.Errorf("context info = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetContextInfo(t *testing.T) {
+	ci := &waProto.ContextInfo{StanzaId: proto.String("3EB0A0")}
+	tests := []struct {
+		name string
+		msg  *waProto.Message
+		get  func(*waProto.Message) *waProto.ContextInfo
+	}{
+		{"text", &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{}}, func(m *waProto.Message) *waProto.ContextInfo { return m.GetExtendedTextMessage().GetContextInfo() }},
+		{"image", &waProto.Message{ImageMessage: &waProto.ImageMessage{}}, func(m *waProto.Message) *waProto.ContextInfo { return m.GetImageMessage().GetContextInfo() }},
+		{"audio", &waProto.Message{AudioMessage: &waProto.AudioMessage{}}, func(m *waProto.Message) *waProto.ContextInfo { return m.GetAudioMessage().GetContextInfo() }},
+		{"sticker", &waProto.Message{StickerMessage: &waProto.StickerMessage{}}, func(m *waProto.Message) *waProto.ContextInfo { return m.GetStickerMessage().GetContextInfo() }},
+		{"location", &waProto.Message{LocationMessage: &waProto.LocationMessage{}}, func(m *waProto.Message) *waProto.ContextInfo { return m.GetLocationMessage().GetContextInfo() }},
+		{"poll", &waProto.Message{PollCreationMessage: &waProto.PollCreationMessage{}}, func(m *waProto.Message) *waProto.ContextInfo { return m.GetPollCreationMessage().GetContextInfo() }},
+		{"buttons", buildButtonsMessage(&sendRequest{Title: "Pick"}), func(m *waProto.Message) *waProto.ContextInfo {
+			return m.GetViewOnceMessage().GetMessage().GetButtonsMessage().GetContextInfo()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setContextInfo(tt.msg, ci)
+			if got := tt.get(tt.msg); got != ci {
+				t.Errorf("context info = %v, want %v", got, ci)
+			}
+		})
+	}
+}
+
+func TestStripContextInfo(t *testing.T) {
+	msg := &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text:        proto.String("answer"),
+			ContextInfo: &waProto.ContextInfo{StanzaId: proto.String("QUESTION")},
+		},
+		MessageContextInfo: &waProto.MessageContextInfo{},
+	}
+	stripped := stripContextInfo(msg)
+	if stripped.GetExtendedTextMessage().GetContextInfo() != nil || stripped.GetMessageContextInfo() != nil {
+		t.Errorf("stripped message still has context info: %v", stripped)
+	}
+	if stripped.GetExtendedTextMessage().GetText() != "answer" {
+		t.Errorf("stripped message lost its text: %v", stripped)
+	}
+	if msg.GetExtendedTextMessage().GetContextInfo() == nil {
+		t.Error("original message was modified")
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vincent-petithory/dataurl"
+)
+
+// Memory used to parse multipart requests, larger files are buffered on disk while parsing
+const multipartMemory = 32 << 20
+
+// Room for the rest of the fields of a JSON send request besides the media
+const jsonPayloadMargin = 1 << 20
+
+// Client used to fetch media by URL. Any user can make the server fetch a URL, so connections
+// to loopback, private and link-local addresses are refused, also when reached by a redirect.
+var mediaFetchClient = &http.Client{
+	Timeout: 2 * time.Minute,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: checkMediaDial,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: checkMediaRedirect,
+}
+
+// Whether media can be fetched from an address
+func publicMediaIP(ip net.IP) bool {
+	return ip != nil && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// Checks the address being dialed, which is already resolved
+func checkMediaDial(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicMediaIP(net.ParseIP(host)) {
+		return fmt.Errorf("media url resolves to a disallowed address %s", host)
+	}
+	return nil
+}
+
+func checkMediaRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("too many redirects")
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("media url redirects to unsupported scheme %s", req.URL.Scheme)
+	}
+	ips, err := net.DefaultResolver.LookupIP(req.Context(), "ip", req.URL.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if !publicMediaIP(ip) {
+			return fmt.Errorf("media url redirects to a disallowed address %s", ip)
+		}
+	}
+	return nil
+}
+
+// Media file to be sent, from a data URL, a fetched URL or a multipart upload
+type mediaFile struct {
+	Data     []byte
+	Mimetype string
+	FileName string
+}
+
+func maxMediaBytes() int64 {
+	return int64(*maxMediaSize) << 20
+}
+
+// Decodes a send request given as JSON or multipart/form-data into t. For multipart requests
+// form fields are mapped to the payload fields, and the uploaded file is returned.
+func decodeSendRequest(w http.ResponseWriter, r *http.Request, t interface{}) (*mediaFile, error) {
+	mediatype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediatype != "multipart/form-data" {
+		// Media in JSON payloads is base64 encoded, which takes a third more than the file
+		r.Body = http.MaxBytesReader(w, r.Body, maxMediaBytes()/3*4+jsonPayloadMargin)
+		err := json.NewDecoder(r.Body).Decode(t)
+		if err != nil {
+			return nil, errors.New("could not decode payload")
+		}
+		return nil, nil
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxMediaBytes()+multipartMemory)
+	err := r.ParseMultipartForm(multipartMemory)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode multipart payload: %v", err)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	// Form values are strings, objects and booleans are passed as their JSON text
+	values := make(map[string]interface{})
+	for key, v := range r.MultipartForm.Value {
+		if len(v) == 0 {
+			continue
+		}
+		value := strings.TrimSpace(v[0])
+		switch {
+		case strings.HasPrefix(value, "{") || strings.HasPrefix(value, "["):
+			values[key] = json.RawMessage(value)
+		case value == "true" || value == "false":
+			values[key] = value == "true"
+		default:
+			values[key] = v[0]
+		}
+	}
+	payload, err := json.Marshal(values)
+	if err == nil {
+		err = json.Unmarshal(payload, t)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decode multipart payload: %v", err)
+	}
+
+	// The file is expected in the field named as the media type, any field is accepted
+	var header *multipart.FileHeader
+	for _, files := range r.MultipartForm.File {
+		if len(files) > 0 {
+			header = files[0]
+			break
+		}
+	}
+	if header == nil {
+		return nil, nil
+	}
+	if header.Size > maxMediaBytes() {
+		return nil, fmt.Errorf("file is larger than %d MB", *maxMediaSize)
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, fmt.Errorf("could not read uploaded file: %v", err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read uploaded file: %v", err)
+	}
+	return &mediaFile{
+		Data:     data,
+		Mimetype: detectMimetype(data, header.Header.Get("Content-Type")),
+		FileName: header.Filename,
+	}, nil
+}
+
+// Gets the media to send: the uploaded file if any, otherwise the data URL or http(s) URL given in the payload
+func getMediaFile(upload *mediaFile, value string) (*mediaFile, error) {
+	if upload != nil {
+		return upload, nil
+	}
+	switch {
+	case strings.HasPrefix(value, "data:"):
+		dataURL, err := dataurl.DecodeString(value)
+		if err != nil {
+			return nil, errors.New("could not decode base64 encoded data from payload")
+		}
+		return &mediaFile{Data: dataURL.Data, Mimetype: detectMimetype(dataURL.Data, dataURL.ContentType())}, nil
+	case strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://"):
+		return fetchMedia(value)
+	}
+	return nil, errors.New("media should be a base64 data URL (\"data:mime/type;base64,...\"), an http(s) URL or a multipart upload")
+}
+
+// Downloads media from a URL, refusing files over the size limit
+func fetchMedia(mediaURL string) (*mediaFile, error) {
+	u, err := url.Parse(mediaURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid media url: %v", err)
+	}
+	resp, err := mediaFetchClient.Get(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch media: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch media: %s", resp.Status)
+	}
+	if resp.ContentLength > maxMediaBytes() {
+		return nil, fmt.Errorf("media is larger than %d MB", *maxMediaSize)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxMediaBytes()+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch media: %v", err)
+	}
+	if int64(len(data)) > maxMediaBytes() {
+		return nil, fmt.Errorf("media is larger than %d MB", *maxMediaSize)
+	}
+
+	fileName := path.Base(u.Path)
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		fileName = params["filename"]
+	}
+	if fileName == "/" || fileName == "." {
+		fileName = ""
+	}
+	return &mediaFile{
+		Data:     data,
+		Mimetype: detectMimetype(data, resp.Header.Get("Content-Type")),
+		FileName: fileName,
+	}, nil
+}
+
+// Sniffs the type of a file, keeping the declared type when sniffing only finds a generic one
+func detectMimetype(data []byte, declared string) string {
+	sniffed := http.DetectContentType(data)
+	declared, _, err := mime.ParseMediaType(declared)
+	if err != nil || declared == "application/octet-stream" {
+		return sniffed
+	}
+	switch {
+	case strings.HasPrefix(sniffed, "application/octet-stream"),
+		strings.HasPrefix(sniffed, "text/plain"),
+		strings.HasPrefix(sniffed, "application/zip"):
+		// Office documents are zip files and many text formats sniff as plain text
+		return declared
+	}
+	return sniffed
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPublicMediaIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"224.0.0.1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+	}
+	for _, tt := range tests {
+		if got := publicMediaIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("publicMediaIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+	if publicMediaIP(nil) {
+		t.Error("publicMediaIP(nil) = true")
+	}
+}
+
+func TestFetchMediaRefusesInternalAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer srv.Close()
+
+	for _, mediaURL := range []string{srv.URL + "/file.jpg", "http://169.254.169.254/latest/meta-data/", "http://[::1]:9/file.jpg"} {
+		if _, err := fetchMedia(mediaURL); err == nil || !strings.Contains(err.Error(), "disallowed address") {
+			t.Errorf("fetchMedia(%s) error = %v, want a disallowed address error", mediaURL, err)
+		}
+	}
+}
+
+func TestCheckMediaRedirect(t *testing.T) {
+	tests := []struct {
+		target string
+		via    int
+		ok     bool
+	}{
+		{"https://93.184.216.34/file.jpg", 1, true},
+		{"http://127.0.0.1/file.jpg", 1, false},
+		{"http://10.0.0.5/file.jpg", 1, false},
+		{"file:///etc/passwd", 1, false},
+		{"https://93.184.216.34/file.jpg", 10, false},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.target)
+		req := &http.Request{URL: u}
+		err := checkMediaRedirect(req, make([]*http.Request, tt.via))
+		if (err == nil) != tt.ok {
+			t.Errorf("checkMediaRedirect(%s, %d redirects) error = %v, want ok %v", tt.target, tt.via, err, tt.ok)
+		}
+	}
+}
+
+func TestDecodeSendRequest(t *testing.T) {
+	defer func(size int) { *maxMediaSize = size }(*maxMediaSize)
+	*maxMediaSize = 1
+
+	t.Run("json", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/chat/send", strings.NewReader(`{"Type":"text","Phone":"5491155554444","Body":"hi"}`))
+		var req sendRequest
+		upload, err := decodeSendRequest(httptest.NewRecorder(), r, &req)
+		if err != nil || upload != nil {
+			t.Fatalf("decodeSendRequest() = %v, %v", upload, err)
+		}
+		if req.Type != "text" || req.Phone != "5491155554444" || req.Body != "hi" {
+			t.Errorf("decoded %+v", &req)
+		}
+	})
+
+	t.Run("json over the size limit", func(t *testing.T) {
+		body := `{"Type":"image","Phone":"5491155554444","Image":"data:image/png;base64,` + strings.Repeat("A", 3<<20) + `"}`
+		r := httptest.NewRequest(http.MethodPost, "/chat/send", strings.NewReader(body))
+		var req sendRequest
+		if _, err := decodeSendRequest(httptest.NewRecorder(), r, &req); err == nil {
+			t.Error("decodeSendRequest() accepted a payload over the size limit")
+		}
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("Type", "document")
+		writer.WriteField("Phone", "5491155554444")
+		writer.WriteField("Queue", "true")
+		writer.WriteField("Quoted", `{"Id":"3EB0A0"}`)
+		part, _ := writer.CreateFormFile("Document", "report.pdf")
+		part.Write([]byte("%PDF-1.4 report"))
+		writer.Close()
+
+		r := httptest.NewRequest(http.MethodPost, "/chat/send", &body)
+		r.Header.Set("Content-Type", writer.FormDataContentType())
+		var req sendRequest
+		upload, err := decodeSendRequest(httptest.NewRecorder(), r, &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Type != "document" || !req.Queue || req.Quoted == nil || req.Quoted.Id != "3EB0A0" {
+			t.Errorf("decoded %+v", &req)
+		}
+		if upload == nil || upload.FileName != "report.pdf" || upload.Mimetype != "application/pdf" {
+			t.Errorf("upload = %+v", upload)
+		}
+	})
+}
+
+func TestDetectMimetype(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	tests := []struct {
+		name     string
+		data     []byte
+		declared string
+		want     string
+	}{
+		{"sniffed over declared", png, "image/jpeg", "image/png"},
+		{"sniffed when undeclared", png, "", "image/png"},
+		{"declared office document", []byte("PK\x03\x04"), "application/vnd.openxmlformats-officedocument.wordprocessingml.document", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"declared text format", []byte("a,b\n1,2\n"), "text/csv; charset=utf-8", "text/csv"},
+		{"generic declared type", []byte("a,b\n1,2\n"), "application/octet-stream", "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectMimetype(tt.data, tt.declared); got != tt.want {
+				t.Errorf("detectMimetype() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
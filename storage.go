@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var errMediaNotFound = errors.New("media not found")
+
+// Storage for received media and history sync dumps, keys look like user_<id>/<file>
+type mediaStorage interface {
+	Put(key string, data []byte, mimetype string) error
+	Open(key string) (io.ReadSeekCloser, storedFile, error)
+	Delete(key string) error
+	List(prefix string) ([]storedFile, error)
+}
+
+// Object kept in media storage
+type storedFile struct {
+	key     string
+	size    int64
+	modTime time.Time
+}
+
+// Storage in use, selected with the -storage flag
+var mediaStore mediaStorage
+
+// Reads a whole object from media storage
+func readMedia(key string) ([]byte, error) {
+	object, _, err := mediaStore.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	return io.ReadAll(object)
+}
+
+// Stores media as files under a local directory
+type localStorage struct {
+	root string
+}
+
+func newLocalStorage(root string) *localStorage {
+	return &localStorage{root: root}
+}
+
+func (l *localStorage) path(key string) string {
+	return filepath.Join(l.root, filepath.FromSlash(key))
+}
+
+func (l *localStorage) Put(key string, data []byte, mimetype string) error {
+	path := l.path(key)
+	err := os.MkdirAll(filepath.Dir(path), 0751)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func (l *localStorage) Open(key string) (io.ReadSeekCloser, storedFile, error) {
+	file, err := os.Open(l.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, storedFile{}, errMediaNotFound
+	} else if err != nil {
+		return nil, storedFile{}, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, storedFile{}, err
+	}
+	return file, storedFile{key: key, size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+func (l *localStorage) Delete(key string) error {
+	err := os.Remove(l.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (l *localStorage) List(prefix string) ([]storedFile, error) {
+	var files []storedFile
+	dir := l.path(prefix)
+	if !strings.HasSuffix(prefix, "/") {
+		dir = filepath.Dir(dir)
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			files = append(files, storedFile{key: key, size: info.Size(), modTime: info.ModTime()})
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return files, err
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	webhookPollInterval = 10 * time.Second
+	webhookLease        = 60 * time.Second
+	webhookBatchSize    = 50
+)
+
+// Used for retries when the user has no active session (and thus no http client)
+var webhookHttp = resty.New().SetTimeout(5 * time.Second)
+
+type webhookDelivery struct {
+	Id        int64
+	UserId    int
+	WebhookId int64
+	Url       string
+	Format    string
+	EventType string
+	Payload   string
+	File      string
+	Attempts  int
+}
+
+// Webhook endpoints by user, invalidated whenever they are changed through the API
+var webhookcache = cache.New(5*time.Minute, 10*time.Minute)
+
+type webhookEndpoint struct {
+	Id        int64
+	Url       string
+	Events    []string
+	Format    string
+	Signed    bool
+	Media     string
+	Schema    string
+	RawEvent  bool
+	CreatedAt time.Time
+}
+
+// Where a webhook is sent, media is upload to send files along or url to link them.
+// Schema is legacy to send the whatsmeow event as is, or v1 for the normalized event.
+type webhookTarget struct {
+	webhookDelivery
+	Media    string
+	Schema   string
+	RawEvent bool
+}
+
+// Gets all webhook endpoints configured for a user
+func getWebhookEndpoints(db *sql.DB, userID int) ([]webhookEndpoint, error) {
+	key := strconv.Itoa(userID)
+	if cached, found := webhookcache.Get(key); found {
+		return cached.([]webhookEndpoint), nil
+	}
+
+	rows, err := db.Query("SELECT id,url,events,format,secret,media,schema,raw,created_at FROM webhooks WHERE user_id=? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	endpoints := []webhookEndpoint{}
+	for rows.Next() {
+		var e webhookEndpoint
+		var events, secret string
+		var createdAt int64
+		if err = rows.Scan(&e.Id, &e.Url, &events, &e.Format, &secret, &e.Media, &e.Schema, &e.RawEvent, &createdAt); err != nil {
+			return nil, err
+		}
+		e.Events = strings.Split(events, ",")
+		e.Signed = secret != ""
+		e.CreatedAt = time.Unix(createdAt, 0)
+		endpoints = append(endpoints, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	webhookcache.Set(key, endpoints, cache.DefaultExpiration)
+	return endpoints, nil
+}
+
+// Validates an event filter against messageTypes, an empty filter subscribes to All
+func parseWebhookEvents(events []string) ([]string, error) {
+	var subscribed []string
+	for _, arg := range events {
+		if !Find(messageTypes, arg) {
+			return nil, fmt.Errorf("unknown event type %s", arg)
+		}
+		if !Find(subscribed, arg) {
+			subscribed = append(subscribed, arg)
+		}
+	}
+	if len(subscribed) == 0 {
+		subscribed = append(subscribed, "All")
+	}
+	return subscribed, nil
+}
+
+// Http client used to post webhooks for a user
+func webhookClient(userID int) *resty.Client {
+	if client, ok := clientHttp[userID]; ok && client != nil {
+		return client
+	}
+	return webhookHttp
+}
+
+// Backoff before the next attempt after the given number of failed attempts
+func webhookBackoff(attempts int) time.Duration {
+	delay := time.Duration(*webhookBackoffSecs) * time.Second
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= time.Hour {
+			return time.Hour
+		}
+	}
+	return delay
+}
+
+// Sends a webhook right away, it is only persisted for retries if this first attempt fails
+// so that high volume events do not write to the database when receivers are healthy
+func queueWebhook(db *sql.DB, d webhookDelivery) {
+	go func() {
+		err := postWebhook(db, d)
+		if err == nil {
+			return
+		}
+		storeFailedWebhook(db, d, err)
+	}()
+}
+
+// Schedules the retry of a webhook that failed its first attempt, or moves it
+// straight to the dead letter table if no retries are allowed
+func storeFailedWebhook(db *sql.DB, d webhookDelivery, failure error) {
+	now := time.Now()
+	d.Attempts = 1
+	var err error
+	if d.Attempts >= *webhookRetries {
+		log.Error().Err(failure).Str("url", d.Url).Int("attempts", d.Attempts).Msg("Webhook failed permanently")
+		_, err = db.Exec(
+			"INSERT INTO webhook_deadletter(user_id,webhook_id,url,format,event_type,payload,file,attempts,last_error,created_at,failed_at) VALUES(?,?,?,?,?,?,?,?,?,?,?)",
+			d.UserId, d.WebhookId, d.Url, d.Format, d.EventType, d.Payload, d.File, d.Attempts, failure.Error(), now.Unix(), now.Unix(),
+		)
+	} else {
+		log.Warn().Err(failure).Str("url", d.Url).Int("attempts", d.Attempts).Msg("Webhook failed, will retry")
+		_, err = db.Exec(
+			"INSERT INTO webhook_queue(user_id,webhook_id,url,format,event_type,payload,file,attempts,next_attempt,last_error,created_at) VALUES(?,?,?,?,?,?,?,?,?,?,?)",
+			d.UserId, d.WebhookId, d.Url, d.Format, d.EventType, d.Payload, d.File, d.Attempts, now.Add(webhookBackoff(d.Attempts)).Unix(), failure.Error(), now.Unix(),
+		)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("url", d.Url).Msg("Could not store failed webhook")
+	}
+}
+
+// Sends a queued webhook, scheduling a retry or moving it to the dead letter table on failure
+func attemptWebhook(db *sql.DB, id int64) {
+	now := time.Now()
+
+	// Claim the delivery so the retry worker does not send it concurrently
+	res, err := db.Exec(
+		"UPDATE webhook_queue SET next_attempt=? WHERE id=? AND next_attempt<=?",
+		now.Add(webhookLease).Unix(), id, now.Unix(),
+	)
+	if err != nil {
+		log.Error().Err(err).Int64("id", id).Msg("Could not claim webhook delivery")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return
+	}
+
+	var d webhookDelivery
+	err = db.QueryRow(
+		"SELECT id,user_id,webhook_id,url,format,event_type,payload,file,attempts FROM webhook_queue WHERE id=?", id,
+	).Scan(&d.Id, &d.UserId, &d.WebhookId, &d.Url, &d.Format, &d.EventType, &d.Payload, &d.File, &d.Attempts)
+	if err != nil {
+		log.Error().Err(err).Int64("id", id).Msg("Could not read webhook delivery")
+		return
+	}
+
+	err = postWebhook(db, d)
+	if err == nil {
+		_, err = db.Exec("DELETE FROM webhook_queue WHERE id=?", id)
+		if err != nil {
+			log.Error().Err(err).Int64("id", id).Msg("Could not remove delivered webhook")
+		}
+		return
+	}
+
+	d.Attempts++
+	if d.Attempts >= *webhookRetries {
+		log.Error().Err(err).Int64("id", id).Str("url", d.Url).Int("attempts", d.Attempts).Msg("Webhook failed permanently")
+		tx, txerr := db.Begin()
+		if txerr != nil {
+			log.Error().Err(txerr).Int64("id", id).Msg("Could not move webhook to dead letter")
+			return
+		}
+		_, txerr = tx.Exec(
+			"INSERT INTO webhook_deadletter(user_id,webhook_id,url,format,event_type,payload,file,attempts,last_error,created_at,failed_at) SELECT user_id,webhook_id,url,format,event_type,payload,file,?,?,created_at,? FROM webhook_queue WHERE id=?",
+			d.Attempts, err.Error(), time.Now().Unix(), id,
+		)
+		if txerr == nil {
+			_, txerr = tx.Exec("DELETE FROM webhook_queue WHERE id=?", id)
+		}
+		if txerr != nil {
+			tx.Rollback()
+			log.Error().Err(txerr).Int64("id", id).Msg("Could not move webhook to dead letter")
+			return
+		}
+		tx.Commit()
+		return
+	}
+
+	log.Warn().Err(err).Int64("id", id).Str("url", d.Url).Int("attempts", d.Attempts).Msg("Webhook failed, will retry")
+	_, err = db.Exec(
+		"UPDATE webhook_queue SET attempts=?,next_attempt=?,last_error=? WHERE id=?",
+		d.Attempts, time.Now().Add(webhookBackoff(d.Attempts)).Unix(), err.Error(), id,
+	)
+	if err != nil {
+		log.Error().Err(err).Int64("id", id).Msg("Could not reschedule webhook")
+	}
+}
+
+// Posts a webhook in the format configured for it
+func postWebhook(db *sql.DB, d webhookDelivery) error {
+	token := ""
+	secret := ""
+	err := db.QueryRow("SELECT token,webhook_secret FROM users WHERE id=?", d.UserId).Scan(&token, &secret)
+	if err == sql.ErrNoRows {
+		return errors.New("user does not exist")
+	} else if err != nil {
+		return fmt.Errorf("could not get user: %v", err)
+	}
+	if d.WebhookId != 0 {
+		err = db.QueryRow("SELECT secret FROM webhooks WHERE id=? AND user_id=?", d.WebhookId, d.UserId).Scan(&secret)
+		if err == sql.ErrNoRows {
+			return errors.New("webhook endpoint does not exist")
+		} else if err != nil {
+			return fmt.Errorf("could not get webhook endpoint: %v", err)
+		}
+	}
+
+	if d.Format == "json" {
+		return callHookJSON(d.Url, []byte(d.Payload), d.UserId, secret)
+	}
+	data := make(map[string]string)
+	data["jsonData"] = d.Payload
+	// Extra endpoints may belong to third parties, they never get the token
+	if *webhookToken && d.WebhookId == 0 {
+		data["token"] = token
+	}
+	if d.File == "" {
+		return callHook(d.Url, data, d.UserId, secret)
+	}
+	file, err := readMedia(d.File)
+	if err == errMediaNotFound {
+		// The file may have been removed by retention meanwhile, the event is still worth delivering
+		log.Warn().Str("file", d.File).Msg("Webhook file no longer in storage, sending without it")
+		return callHook(d.Url, data, d.UserId, secret)
+	} else if err != nil {
+		return fmt.Errorf("could not read webhook file: %v", err)
+	}
+	return callHookFile(d.Url, data, d.UserId, path.Base(d.File), file, secret)
+}
+
+// Retries due webhook deliveries for all users
+func (s *server) webhookRetryWorker() {
+	for {
+		time.Sleep(webhookPollInterval)
+		rows, err := s.db.Query(
+			"SELECT id FROM webhook_queue WHERE next_attempt<=? ORDER BY next_attempt LIMIT ?",
+			time.Now().Unix(), webhookBatchSize,
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("Could not read webhook queue")
+			continue
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err = rows.Scan(&id); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+		for _, id := range ids {
+			attemptWebhook(s.db, id)
+		}
+	}
+}
+
+// Moves dead letter webhooks back into the delivery queue
+func replayWebhooks(db *sql.DB, userID int, ids []int64) (int, error) {
+	var rows *sql.Rows
+	var err error
+	if len(ids) == 0 {
+		rows, err = db.Query("SELECT id FROM webhook_deadletter WHERE user_id=?", userID)
+		if err != nil {
+			return 0, err
+		}
+		for rows.Next() {
+			var id int64
+			if err = rows.Scan(&id); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+	}
+
+	replayed := 0
+	for _, id := range ids {
+		now := time.Now().Unix()
+		tx, err := db.Begin()
+		if err != nil {
+			return replayed, err
+		}
+		res, err := tx.Exec(
+			"INSERT INTO webhook_queue(user_id,webhook_id,url,format,event_type,payload,file,attempts,next_attempt,last_error,created_at) SELECT user_id,webhook_id,url,format,event_type,payload,file,0,?,'',created_at FROM webhook_deadletter WHERE id=? AND user_id=?",
+			now, id, userID,
+		)
+		if err != nil {
+			tx.Rollback()
+			return replayed, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			tx.Rollback()
+			continue
+		}
+		newid, _ := res.LastInsertId()
+		_, err = tx.Exec("DELETE FROM webhook_deadletter WHERE id=?", id)
+		if err != nil {
+			tx.Rollback()
+			return replayed, err
+		}
+		if err = tx.Commit(); err != nil {
+			return replayed, err
+		}
+		log.Info().Str("userid", strconv.Itoa(userID)).Int64("id", id).Msg("Replaying webhook")
+		go attemptWebhook(db, newid)
+		replayed++
+	}
+	return replayed, nil
+}
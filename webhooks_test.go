@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestWebhookBackoff(t *testing.T) {
+	defer func(secs int) { *webhookBackoffSecs = secs }(*webhookBackoffSecs)
+	*webhookBackoffSecs = 10
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{5, 160 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{50, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := webhookBackoff(tt.attempts); got != tt.want {
+			t.Errorf("webhookBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestStoreFailedWebhook(t *testing.T) {
+	defer func(retries int) { *webhookRetries = retries }(*webhookRetries)
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE webhook_queue (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, user_id INTEGER NOT NULL, webhook_id INTEGER NOT NULL default 0, url TEXT NOT NULL, format TEXT NOT NULL, event_type TEXT NOT NULL, payload TEXT NOT NULL, file TEXT NOT NULL default "", attempts INTEGER NOT NULL default 0, next_attempt INTEGER NOT NULL, last_error TEXT NOT NULL default "", created_at INTEGER NOT NULL);
+	CREATE TABLE webhook_deadletter (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, user_id INTEGER NOT NULL, webhook_id INTEGER NOT NULL default 0, url TEXT NOT NULL, format TEXT NOT NULL, event_type TEXT NOT NULL, payload TEXT NOT NULL, file TEXT NOT NULL default "", attempts INTEGER NOT NULL, last_error TEXT NOT NULL, created_at INTEGER NOT NULL, failed_at INTEGER NOT NULL);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		retries int
+		table   string
+	}{
+		{"retried", 5, "webhook_queue"},
+		{"no retries allowed", 1, "webhook_deadletter"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*webhookRetries = tt.retries
+			d := webhookDelivery{UserId: i + 1, WebhookId: 3, Url: "http://hooks.example.com", Format: "json", EventType: "Message", Payload: `{"type":"Message"}`}
+			before := time.Now().Unix()
+			storeFailedWebhook(db, d, errors.New("webhook returned status 503"))
+
+			var stored webhookDelivery
+			var lastError string
+			err := db.QueryRow("SELECT webhook_id,url,format,event_type,payload,attempts,last_error FROM "+tt.table+" WHERE user_id=?", d.UserId).
+				Scan(&stored.WebhookId, &stored.Url, &stored.Format, &stored.EventType, &stored.Payload, &stored.Attempts, &lastError)
+			if err != nil {
+				t.Fatalf("delivery not stored in %s: %v", tt.table, err)
+			}
+			d.UserId, d.Attempts = 0, 1
+			if stored != d || lastError != "webhook returned status 503" {
+				t.Errorf("stored %+v with error %q, want %+v", stored, lastError, d)
+			}
+			if tt.table == "webhook_queue" {
+				var next int64
+				db.QueryRow("SELECT next_attempt FROM webhook_queue WHERE user_id=?", i+1).Scan(&next)
+				if next < before+10 {
+					t.Errorf("next attempt in %ds, want the first backoff", next-before)
+				}
+			}
+		})
+	}
+}
+
+func TestPostWebhookToken(t *testing.T) {
+	defer func(legacy bool) { *webhookToken = legacy }(*webhookToken)
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE users (id INTEGER NOT NULL PRIMARY KEY, token TEXT NOT NULL, webhook_secret TEXT NOT NULL default "");
+	CREATE TABLE webhooks (id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, user_id INTEGER NOT NULL, secret TEXT NOT NULL default "");
+	INSERT INTO users(id,token,webhook_secret) VALUES(1,'usertoken','usersecret');
+	INSERT INTO webhooks(id,user_id,secret) VALUES(7,1,'endpointsecret');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type received struct {
+		form      url.Values
+		signature string
+		body      []byte
+		timestamp string
+	}
+	requests := make(chan received, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+		requests <- received{form, r.Header.Get("X-Wuzapi-Signature"), body, r.Header.Get("X-Wuzapi-Timestamp")}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		legacy    bool
+		webhookID int64
+		wantToken string
+		secret    string
+	}{
+		{"user webhook", false, 0, "", "usersecret"},
+		{"user webhook with -webhooktoken", true, 0, "usertoken", "usersecret"},
+		{"extra endpoint", false, 7, "", "endpointsecret"},
+		{"extra endpoint with -webhooktoken", true, 7, "", "endpointsecret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*webhookToken = tt.legacy
+			d := webhookDelivery{UserId: 1, WebhookId: tt.webhookID, Url: srv.URL, Format: "form", EventType: "Message", Payload: `{"type":"Message"}`}
+			if err := postWebhook(db, d); err != nil {
+				t.Fatal(err)
+			}
+			r := <-requests
+			if got := r.form.Get("token"); got != tt.wantToken {
+				t.Errorf("token = %q, want %q", got, tt.wantToken)
+			}
+			if r.form.Get("jsonData") != d.Payload {
+				t.Errorf("jsonData = %q, want %q", r.form.Get("jsonData"), d.Payload)
+			}
+			if want := signWebhook(tt.secret, r.timestamp, r.body); r.signature != want {
+				t.Errorf("signature = %s, want %s", r.signature, want)
+			}
+		})
+	}
+}
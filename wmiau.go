@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"database/sql"
@@ -13,6 +14,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -34,6 +36,7 @@ import (
 // var wlog waLog.Logger
 var clientPointer = make(map[int]*whatsmeow.Client)
 var clientHttp = make(map[int]*resty.Client)
+var myClientPointer = make(map[int]*MyClient)
 var historySyncID int32
 
 type MyClient struct {
@@ -43,11 +46,26 @@ type MyClient struct {
 	token          string
 	subscriptions  []string
 	db             *sql.DB
+	mu             sync.RWMutex
+}
+
+// Token and subscriptions can be changed through the admin API while the client runs
+func (mycli *MyClient) session() (string, []string) {
+	mycli.mu.RLock()
+	defer mycli.mu.RUnlock()
+	return mycli.token, mycli.subscriptions
+}
+
+func (mycli *MyClient) setSession(token string, subscriptions []string) {
+	mycli.mu.Lock()
+	defer mycli.mu.Unlock()
+	mycli.token = token
+	mycli.subscriptions = subscriptions
 }
 
 // Connects to Whatsapp Websocket on server startup if last state was connected
 func (s *server) connectOnStartup() {
-	rows, err := s.db.Query("SELECT id,token,jid,webhook,events FROM users WHERE connected=1")
+	rows, err := s.db.Query("SELECT id,token,jid,webhook,events,webhook_format,webhook_secret,webhook_media,webhook_schema,webhook_raw,media_download FROM users WHERE connected=1")
 	if err != nil {
 		log.Error().Err(err).Msg("DB Problem")
 		return
@@ -59,40 +77,35 @@ func (s *server) connectOnStartup() {
 		jid := ""
 		webhook := ""
 		events := ""
-		err = rows.Scan(&txtid, &token, &jid, &webhook, &events)
+		webhookFormat := ""
+		webhookSecret := ""
+		webhookMedia := ""
+		webhookSchema := ""
+		webhookRaw := ""
+		mediaDownload := ""
+		err = rows.Scan(&txtid, &token, &jid, &webhook, &events, &webhookFormat, &webhookSecret, &webhookMedia, &webhookSchema, &webhookRaw, &mediaDownload)
 		if err != nil {
 			log.Error().Err(err).Msg("DB Problem")
 			return
 		} else {
 			log.Info().Str("token", token).Msg("Connect to Whatsapp on startup")
 			v := Values{map[string]string{
-				"Id":      txtid,
-				"Jid":     jid,
-				"Webhook": webhook,
-				"Token":   token,
-				"Events":  events,
+				"Id":            txtid,
+				"Jid":           jid,
+				"Webhook":       webhook,
+				"Token":         token,
+				"Events":        events,
+				"WebhookFormat": webhookFormat,
+				"WebhookSecret": webhookSecret,
+				"WebhookMedia":  webhookMedia,
+				"WebhookSchema": webhookSchema,
+				"WebhookRaw":    webhookRaw,
+				"MediaDownload": mediaDownload,
 			}}
 			userinfocache.Set(token, v, cache.NoExpiration)
 			userid, _ := strconv.Atoi(txtid)
 			// Gets and set subscription to webhook events
-			eventarray := strings.Split(events, ",")
-
-			var subscribedEvents []string
-			if len(eventarray) < 1 {
-				if !Find(subscribedEvents, "All") {
-					subscribedEvents = append(subscribedEvents, "All")
-				}
-			} else {
-				for _, arg := range eventarray {
-					if !Find(messageTypes, arg) {
-						log.Warn().Str("Type", arg).Msg("Message type discarded")
-						continue
-					}
-					if !Find(subscribedEvents, arg) {
-						subscribedEvents = append(subscribedEvents, arg)
-					}
-				}
-			}
+			subscribedEvents := parseSubscriptions(strings.Split(events, ","))
 			eventstring := strings.Join(subscribedEvents, ",")
 			log.Info().Str("events", eventstring).Str("jid", jid).Msg("Attempt to connect")
 			killchannel[userid] = make(chan bool)
@@ -192,8 +205,9 @@ func (s *server) startClient(userID int, textjid string, token string, subscript
 		client = whatsmeow.NewClient(deviceStore, nil)
 	}
 	clientPointer[userID] = client
-	mycli := MyClient{client, 1, userID, token, subscriptions, s.db}
+	mycli := &MyClient{WAClient: client, eventHandlerID: 1, userID: userID, token: token, subscriptions: subscriptions, db: s.db}
 	mycli.eventHandlerID = mycli.WAClient.AddEventHandler(mycli.myEventHandler)
+	myClientPointer[userID] = mycli
 	clientHttp[userID] = resty.New()
 	clientHttp[userID].SetRedirectPolicy(resty.FlexibleRedirectPolicy(15))
 	if *waDebug == "DEBUG" {
@@ -265,13 +279,19 @@ func (s *server) startClient(userID int, textjid string, token string, subscript
 		}
 	}
 
+	// Send queued messages while the client is alive
+	stopqueue := make(chan bool)
+	go s.queueWorker(userID, client, stopqueue)
+
 	// Keep connected client live until disconnected/killed
 	for {
 		select {
 		case <-killchannel[userID]:
 			log.Info().Str("userid", strconv.Itoa(userID)).Msg("Received kill signal")
+			close(stopqueue)
 			client.Disconnect()
 			delete(clientPointer, userID)
+			delete(myClientPointer, userID)
 			sqlStmt := `UPDATE users SET connected=0 WHERE id=?`
 			_, err := s.db.Exec(sqlStmt, userID)
 			if err != nil {
@@ -291,12 +311,7 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 	postmap["event"] = rawEvt
 	dowebhook := 0
 	path := ""
-
-	ex, err := os.Executable()
-	if err != nil {
-		panic(err)
-	}
-	exPath := filepath.Dir(ex)
+	mediaurl := ""
 
 	switch evt := rawEvt.(type) {
 	case *events.AppStateSyncComplete:
@@ -327,7 +342,8 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 			return
 		}
 	case *events.PairSuccess:
-		log.Info().Str("userid", strconv.Itoa(mycli.userID)).Str("token", mycli.token).Str("ID", evt.ID.String()).Str("BusinessName", evt.BusinessName).Str("Platform", evt.Platform).Msg("QR Pair Success")
+		token, _ := mycli.session()
+		log.Info().Str("userid", strconv.Itoa(mycli.userID)).Str("token", token).Str("ID", evt.ID.String()).Str("BusinessName", evt.BusinessName).Str("Platform", evt.Platform).Msg("QR Pair Success")
 		jid := evt.ID
 		sqlStmt := `UPDATE users SET jid=? WHERE id=?`
 		_, err := mycli.db.Exec(sqlStmt, jid, mycli.userID)
@@ -336,12 +352,11 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 			return
 		}
 
-		myuserinfo, found := userinfocache.Get(mycli.token)
+		myuserinfo, found := userinfocache.Get(token)
 		if !found {
 			log.Warn().Msg("No user info cached on pairing?")
 		} else {
 			txtid := myuserinfo.(Values).Get("Id")
-			token := myuserinfo.(Values).Get("Token")
 			v := updateUserInfo(myuserinfo, "Jid", jid.String())
 			userinfocache.Set(token, v, cache.NoExpiration)
 			log.Info().Str("jid", jid.String()).Str("userid", txtid).Str("token", token).Msg("User information set")
@@ -352,6 +367,9 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 	case *events.Message:
 		postmap["type"] = "Message"
 		dowebhook = 1
+		if mycli.applyProtocolMessage(evt, postmap) || mycli.applyPollVote(evt, postmap) {
+			break
+		}
 		metaParts := []string{fmt.Sprintf("pushname: %s", evt.Info.PushName), fmt.Sprintf("timestamp: %s", evt.Info.Timestamp)}
 		if evt.Info.Type != "" {
 			metaParts = append(metaParts, fmt.Sprintf("type: %s", evt.Info.Type))
@@ -368,116 +386,146 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 
 		log.Info().Str("id", evt.Info.ID).Str("source", evt.Info.SourceString()).Str("parts", strings.Join(metaParts, ", ")).Msg("Message Received")
 
+		created, err := storeChatMessage(mycli.db, mycli.userID, &evt.Info, evt.Message)
+		if err != nil {
+			log.Error().Err(err).Str("id", evt.Info.ID).Msg("Could not store message")
+		} else if created && evt.Info.IsGroup {
+			go mycli.updateGroupName(evt.Info.Chat)
+		}
+
+		if reply := getInteractiveReply(evt.Message); reply != nil {
+			postmap["type"] = "InteractiveReply"
+			postmap["replyType"] = reply.Type
+			postmap["selectedId"] = reply.Id
+			postmap["title"] = reply.Title
+			postmap["messageId"] = reply.MessageId
+			log.Info().Str("id", evt.Info.ID).Str("type", reply.Type).Str("selected", reply.Id).Msg("Interactive reply received")
+		}
+
 		// try to get Image if any
 		img := evt.Message.GetImageMessage()
-		if img != nil {
-
-			// check/creates user directory for files
-			userDirectory := fmt.Sprintf("%s/files/user_%s", exPath, txtid)
-			_, err := os.Stat(userDirectory)
-			if os.IsNotExist(err) {
-				errDir := os.MkdirAll(userDirectory, 0751)
-				if errDir != nil {
-					log.Error().Err(errDir).Msg("Could not create user directory")
-					return
-				}
-			}
+		if img != nil && mycli.autoDownload("image") {
 
 			data, err := mycli.WAClient.Download(img)
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to download image")
 				return
 			}
+			extension := ".jpg"
 			exts, _ := mime.ExtensionsByType(img.GetMimetype())
-			path = fmt.Sprintf("%s/%s%s", userDirectory, evt.Info.ID, exts[0])
-			err = os.WriteFile(path, data, 0600)
+			if len(exts) > 0 {
+				extension = exts[0]
+			}
+			path = fmt.Sprintf("user_%s/%s%s", txtid, evt.Info.ID, extension)
+			err = mediaStore.Put(path, data, img.GetMimetype())
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to save image")
 				return
 			}
-			log.Info().Str("path", path).Msg("Image saved")
+			log.Info().Str("key", path).Msg("Image saved")
 		}
 
 		// try to get Audio if any
 		audio := evt.Message.GetAudioMessage()
-		if audio != nil {
-
-			// check/creates user directory for files
-			userDirectory := fmt.Sprintf("%s/files/user_%s", exPath, txtid)
-			_, err := os.Stat(userDirectory)
-			if os.IsNotExist(err) {
-				errDir := os.MkdirAll(userDirectory, 0751)
-				if errDir != nil {
-					log.Error().Err(errDir).Msg("Could not create user directory")
-					return
-				}
-			}
+		if audio != nil && mycli.autoDownload("audio") {
 
 			data, err := mycli.WAClient.Download(audio)
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to download audio")
 				return
 			}
+			extension := ".ogg"
 			exts, _ := mime.ExtensionsByType(audio.GetMimetype())
-			path = fmt.Sprintf("%s/%s%s", userDirectory, evt.Info.ID, exts[0])
-			err = os.WriteFile(path, data, 0600)
+			if len(exts) > 0 {
+				extension = exts[0]
+			}
+			path = fmt.Sprintf("user_%s/%s%s", txtid, evt.Info.ID, extension)
+			err = mediaStore.Put(path, data, audio.GetMimetype())
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to save audio")
 				return
 			}
-			log.Info().Str("path", path).Msg("Audio saved")
+			log.Info().Str("key", path).Msg("Audio saved")
+		}
+
+		// try to get Video if any
+		video := evt.Message.GetVideoMessage()
+		if video != nil && mycli.autoDownload("video") {
+
+			data, err := mycli.WAClient.Download(video)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to download video")
+				return
+			}
+			extension := ".mp4"
+			exts, _ := mime.ExtensionsByType(video.GetMimetype())
+			if len(exts) > 0 {
+				extension = exts[0]
+			}
+			path = fmt.Sprintf("user_%s/%s%s", txtid, evt.Info.ID, extension)
+			err = mediaStore.Put(path, data, video.GetMimetype())
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to save video")
+				return
+			}
+			log.Info().Str("key", path).Msg("Video saved")
 		}
 
 		// try to get Document if any
 		document := evt.Message.GetDocumentMessage()
-		if document != nil {
-
-			// check/creates user directory for files
-			userDirectory := fmt.Sprintf("%s/files/user_%s", exPath, txtid)
-			_, err := os.Stat(userDirectory)
-			if os.IsNotExist(err) {
-				errDir := os.MkdirAll(userDirectory, 0751)
-				if errDir != nil {
-					log.Error().Err(errDir).Msg("Could not create user directory")
-					return
-				}
-			}
+		if document != nil && mycli.autoDownload("document") {
 
 			data, err := mycli.WAClient.Download(document)
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to download document")
 				return
 			}
-			extension := ""
-			exts, err := mime.ExtensionsByType(document.GetMimetype())
-			if err != nil {
-				extension = exts[0]
-			} else {
-				filename := document.FileName
-				extension = filepath.Ext(*filename)
+			extension := filepath.Ext(document.GetFileName())
+			if extension == "" {
+				exts, _ := mime.ExtensionsByType(document.GetMimetype())
+				if len(exts) > 0 {
+					extension = exts[0]
+				}
 			}
-			path = fmt.Sprintf("%s/%s%s", userDirectory, evt.Info.ID, extension)
-			err = os.WriteFile(path, data, 0600)
+			path = fmt.Sprintf("user_%s/%s%s", txtid, evt.Info.ID, extension)
+			err = mediaStore.Put(path, data, document.GetMimetype())
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to save document")
 				return
 			}
-			log.Info().Str("path", path).Msg("Document saved")
+			log.Info().Str("key", path).Msg("Document saved")
+		}
+
+		if path != "" {
+			err = setMessageMedia(mycli.db, mycli.userID, evt.Info.Chat, evt.Info.ID, path)
+			if err != nil {
+				log.Error().Err(err).Str("id", evt.Info.ID).Msg("Could not store message media")
+			}
+			// Links are only useful to webhook receivers with an absolute url
+			if *publicURL != "" {
+				mediaurl = strings.TrimRight(*publicURL, "/") + "/media/" + evt.Info.ID
+			}
 		}
 	case *events.Receipt:
 		postmap["type"] = "ReadReceipt"
 		dowebhook = 1
 		switch evt.Type {
 		case events.ReceiptTypeRead, events.ReceiptTypeReadSelf:
-			log.Info().Strs("id", evt.MessageIDs).Str("source", evt.SourceString()).Str("timestamp", fmt.Sprintf("%d", evt.Timestamp)).Msg("Message was read")
+			log.Info().Strs("id", evt.MessageIDs).Str("source", evt.SourceString()).Str("timestamp", fmt.Sprintf("%v", evt.Timestamp)).Msg("Message was read")
 			if evt.Type == events.ReceiptTypeRead {
 				postmap["state"] = "Read"
+				updateMessageStatus(mycli.db, mycli.userID, evt.MessageIDs, MessageRead)
 			} else {
 				postmap["state"] = "ReadSelf"
+				err := markChatRead(mycli.db, mycli.userID, evt.Chat)
+				if err != nil {
+					log.Error().Err(err).Str("chat", evt.Chat.String()).Msg("Could not update chat")
+				}
 			}
 		case events.ReceiptTypeDelivered:
 			postmap["state"] = "Delivered"
-			log.Info().Str("id", evt.MessageIDs[0]).Str("source", evt.SourceString()).Str("timestamp", fmt.Sprintf("%d", evt.Timestamp)).Msg("Message delivered")
+			updateMessageStatus(mycli.db, mycli.userID, evt.MessageIDs, MessageDelivered)
+			log.Info().Str("id", evt.MessageIDs[0]).Str("source", evt.SourceString()).Str("timestamp", fmt.Sprintf("%v", evt.Timestamp)).Msg("Message delivered")
 		default:
 			// Discard webhooks for inactive or other delivery types
 			return
@@ -490,7 +538,7 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 			if evt.LastSeen.IsZero() {
 				log.Info().Str("from", evt.From.String()).Msg("User is now offline")
 			} else {
-				log.Info().Str("from", evt.From.String()).Str("lastSeen", fmt.Sprintf("%d", evt.LastSeen)).Msg("User is now offline")
+				log.Info().Str("from", evt.From.String()).Str("lastSeen", fmt.Sprintf("%v", evt.LastSeen)).Msg("User is now offline")
 			}
 		} else {
 			postmap["state"] = "online"
@@ -500,35 +548,27 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 		postmap["type"] = "HistorySync"
 		dowebhook = 1
 
-		// check/creates user directory for files
-		userDirectory := fmt.Sprintf("%s/files/user_%s", exPath, txtid)
-		_, err := os.Stat(userDirectory)
-		if os.IsNotExist(err) {
-			errDir := os.MkdirAll(userDirectory, 0751)
-			if errDir != nil {
-				log.Error().Err(errDir).Msg("Could not create user directory")
-				return
-			}
-		}
-
 		id := atomic.AddInt32(&historySyncID, 1)
-		fileName := fmt.Sprintf("%s/history-%d.json", userDirectory, id)
-		file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE, 0600)
+		key := fmt.Sprintf("user_%s/history-%d.json", txtid, id)
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(evt.Data)
 		if err != nil {
-			log.Error().Err(err).Msg("Failed to open file to write history sync")
+			log.Error().Err(err).Msg("Failed to encode history sync")
 			return
 		}
-		enc := json.NewEncoder(file)
-		enc.SetIndent("", "  ")
-		err = enc.Encode(evt.Data)
+		err = mediaStore.Put(key, buf.Bytes(), "application/json")
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to write history sync")
 			return
 		}
-		log.Info().Str("filename", fileName).Msg("Wrote history sync")
-		_ = file.Close()
+		log.Info().Str("key", key).Msg("Wrote history sync")
+
+		mycli.storeHistorySync(evt.Data)
 	case *events.AppState:
 		log.Info().Str("index", fmt.Sprintf("%+v", evt.Index)).Str("actionValue", fmt.Sprintf("%+v", evt.SyncActionValue)).Msg("App state event received")
+		mycli.applyChatAction(evt)
 	case *events.LoggedOut:
 		log.Info().Str("reason", evt.Reason.String()).Msg("Logged out")
 		killchannel[mycli.userID] <- true
@@ -544,54 +584,368 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 		log.Info().Str("state", string(evt.State)).Str("media", string(evt.Media)).Str("chat", evt.MessageSource.Chat.String()).Str("sender", evt.MessageSource.Sender.String()).Msg("Chat Presence received")
 	case *events.CallOffer:
 		log.Info().Str("event", fmt.Sprintf("%+v", evt)).Msg("Got call offer")
+		postmap["type"] = "Call"
+		dowebhook = 1
+		call := newCallInfo(evt.BasicCallMeta, "offer", evt.Data)
+		mycli.handleIncomingCall(call, evt.CallCreator)
+		postmap["call"] = call
 	case *events.CallAccept:
 		log.Info().Str("event", fmt.Sprintf("%+v", evt)).Msg("Got call accept")
+		postmap["type"] = "Call"
+		dowebhook = 1
+		postmap["call"] = newCallInfo(evt.BasicCallMeta, "accept", evt.Data)
 	case *events.CallTerminate:
 		log.Info().Str("event", fmt.Sprintf("%+v", evt)).Msg("Got call terminate")
+		postmap["type"] = "Call"
+		dowebhook = 1
+		call := newCallInfo(evt.BasicCallMeta, "terminate", nil)
+		call.Reason = evt.Reason
+		postmap["call"] = call
 	case *events.CallOfferNotice:
 		log.Info().Str("event", fmt.Sprintf("%+v", evt)).Msg("Got call offer notice")
+		postmap["type"] = "Call"
+		dowebhook = 1
+		call := newCallInfo(evt.BasicCallMeta, "offer", evt.Data)
+		call.IsVideo = evt.Media == "video"
+		call.IsGroup = evt.Type == "group"
+		mycli.handleIncomingCall(call, evt.CallCreator)
+		postmap["call"] = call
 	case *events.CallRelayLatency:
 		log.Info().Str("event", fmt.Sprintf("%+v", evt)).Msg("Got call relay latency")
+	case *events.GroupInfo:
+		dowebhook = 1
+		if request := newJoinRequestEvent(evt); request != nil {
+			log.Info().Str("group", evt.JID.String()).Strs("requests", request.Requests).Msg("Group join requested")
+			postmap["type"] = "GroupJoinRequest"
+			postmap["request"] = request
+			break
+		}
+		log.Info().Str("group", evt.JID.String()).Msg("Group updated")
+		postmap["type"] = "GroupUpdate"
+		postmap["group"] = newGroupUpdate(evt)
+	case *events.Picture:
+		if evt.JID.Server != types.GroupServer {
+			log.Info().Str("jid", evt.JID.String()).Msg("Picture changed")
+			break
+		}
+		log.Info().Str("group", evt.JID.String()).Msg("Group photo changed")
+		postmap["type"] = "GroupUpdate"
+		dowebhook = 1
+		postmap["group"] = newGroupPhotoUpdate(evt)
+	case *events.JoinedGroup:
+		log.Info().Str("group", evt.JID.String()).Str("reason", evt.Reason).Msg("Joined group")
+		postmap["type"] = "JoinedGroup"
+		dowebhook = 1
+		postmap["group"] = evt.GroupInfo
+		postmap["reason"] = evt.Reason
+		postmap["joinType"] = evt.Type
 	default:
 		log.Warn().Str("event", fmt.Sprintf("%+v", evt)).Msg("Unhandled event")
 	}
 
 	if dowebhook == 1 {
-		// call webhook
+		eventType := postmap["type"].(string)
+		token, subscriptions := mycli.session()
+		formValues, _ := json.Marshal(postmap)
+
+		// Webhooks set to link media, and stream clients, get its url instead of the file
+		var mediaLink map[string]interface{}
+		var mediaValues []byte
+		if mediaurl != "" {
+			mediaLink = map[string]interface{}{
+				"url":      mediaurl,
+				"name":     filepath.Base(path),
+				"mimetype": mime.TypeByExtension(filepath.Ext(path)),
+			}
+			mediaValues, _ = json.Marshal(withFields(postmap, map[string]interface{}{"media": mediaLink}))
+		}
+
+		// Event stream clients get the same events as the webhook set for the user
+		if Find(subscriptions, eventType) || Find(subscriptions, "All") {
+			if mediaValues != nil {
+				getEventHub(mycli.userID).publish(eventType, mediaValues)
+			} else {
+				getEventHub(mycli.userID).publish(eventType, formValues)
+			}
+		}
+
+		var targets []webhookTarget
+
+		// Webhook set for the user, filtered by the events subscribed on connect
 		webhookurl := ""
-		myuserinfo, found := userinfocache.Get(mycli.token)
+		webhookformat := ""
+		webhookmedia := ""
+		webhookschema := ""
+		webhookraw := false
+		myuserinfo, found := userinfocache.Get(token)
 		if !found {
 			log.Warn().
-				Str("token", mycli.token).
+				Str("token", token).
 				Msg("Could not call webhook as there is no user for this token")
 		} else {
 			webhookurl = myuserinfo.(Values).Get("Webhook")
+			webhookformat = myuserinfo.(Values).Get("WebhookFormat")
+			webhookmedia = myuserinfo.(Values).Get("WebhookMedia")
+			webhookschema = myuserinfo.(Values).Get("WebhookSchema")
+			webhookraw = myuserinfo.(Values).Get("WebhookRaw") == "1"
+		}
+		if webhookurl != "" {
+			if !Find(subscriptions, eventType) && !Find(subscriptions, "All") {
+				log.Warn().
+					Str("type", eventType).
+					Msg("Skipping webhook. Not subscribed for this type")
+			} else {
+				targets = append(targets, webhookTarget{webhookDelivery{Url: webhookurl, Format: webhookformat}, webhookmedia, webhookschema, webhookraw})
+			}
 		}
 
-		if !Find(mycli.subscriptions, postmap["type"].(string)) &&
-			!Find(mycli.subscriptions, "All") {
-			log.Warn().
-				Str("type", postmap["type"].(string)).
-				Msg("Skipping webhook. Not subscribed for this type")
+		// Additional webhook endpoints, each with its own event filter
+		endpoints, err := getWebhookEndpoints(mycli.db, mycli.userID)
+		if err != nil {
+			log.Error().Err(err).Msg("Could not get webhook endpoints")
+		}
+		for _, endpoint := range endpoints {
+			if Find(endpoint.Events, eventType) || Find(endpoint.Events, "All") {
+				targets = append(targets, webhookTarget{webhookDelivery{WebhookId: endpoint.Id, Url: endpoint.Url, Format: endpoint.Format}, endpoint.Media, endpoint.Schema, endpoint.RawEvent})
+			}
+		}
+
+		if len(targets) == 0 {
+			log.Warn().Str("userid", strconv.Itoa(mycli.userID)).Str("type", eventType).Msg("No webhook set for user")
 			return
 		}
 
-		if webhookurl != "" {
-			log.Info().Str("url", webhookurl).Msg("Calling webhook")
-			values, _ := json.Marshal(postmap)
-			if path == "" {
-				data := make(map[string]string)
-				data["jsonData"] = string(values)
-				data["token"] = mycli.token
-				go callHook(webhookurl, data, mycli.userID)
-			} else {
-				data := make(map[string]string)
-				data["jsonData"] = string(values)
-				data["token"] = mycli.token
-				go callHookFile(webhookurl, data, mycli.userID, path)
+		var normalized map[string]interface{}
+		var fileValue map[string]interface{}
+		fileRead := false
+		for _, target := range targets {
+			log.Info().Str("url", target.Url).Msg("Calling webhook")
+			target.UserId = mycli.userID
+			target.EventType = eventType
+			if target.Format != "json" {
+				target.Format = "form"
 			}
+
+			payload := postmap
+			if target.Schema == "v1" {
+				if normalized == nil {
+					normalized = normalizeEvent(rawEvt, postmap, mediaurl)
+				}
+				payload = normalized
+				if target.RawEvent {
+					payload = withFields(payload, map[string]interface{}{"event": rawEvt})
+				}
+			} else if mediaLink != nil && target.Media == "url" {
+				payload = withFields(payload, map[string]interface{}{"media": mediaLink})
+			}
+
+			// Files are sent along unless the webhook links media
+			if path != "" && (mediaLink == nil || target.Media != "url") {
+				if target.Format == "json" {
+					if !fileRead {
+						fileRead = true
+						filedata, err := readMedia(path)
+						if err != nil {
+							log.Error().Err(err).Str("path", path).Msg("Could not read file for webhook")
+						} else {
+							fileValue = map[string]interface{}{
+								"name":     filepath.Base(path),
+								"mimetype": mime.TypeByExtension(filepath.Ext(path)),
+								"data":     base64.StdEncoding.EncodeToString(filedata),
+							}
+						}
+					}
+					if fileValue != nil {
+						payload = withFields(payload, map[string]interface{}{"file": fileValue})
+					}
+				} else {
+					target.File = path
+				}
+			}
+
+			values, _ := json.Marshal(payload)
+			target.Payload = string(values)
+			queueWebhook(mycli.db, target.webhookDelivery)
+		}
+	}
+}
+
+// Version of the normalized event schema, sent to webhooks with schema v1
+const eventSchemaVersion = 1
+
+// Media of a message in the normalized event schema
+type eventMedia struct {
+	Url       string `json:"url,omitempty"`
+	Mimetype  string `json:"mimetype"`
+	FileName  string `json:"fileName,omitempty"`
+	Size      uint64 `json:"size"`
+	Seconds   uint32 `json:"seconds,omitempty"`
+	Width     uint32 `json:"width,omitempty"`
+	Height    uint32 `json:"height,omitempty"`
+	VoiceNote bool   `json:"voiceNote,omitempty"`
+}
+
+// Message replied to in the normalized event schema
+type eventQuoted struct {
+	Id          string `json:"id"`
+	Participant string `json:"participant,omitempty"`
+	Kind        string `json:"kind,omitempty"`
+	Text        string `json:"text,omitempty"`
+}
+
+// Message in the normalized event schema, kept stable across whatsmeow upgrades
+type eventMessage struct {
+	Id        string       `json:"id"`
+	Chat      string       `json:"chat"`
+	Sender    string       `json:"sender"`
+	PushName  string       `json:"pushName,omitempty"`
+	Timestamp time.Time    `json:"timestamp"`
+	Kind      string       `json:"kind"`
+	Text      string       `json:"text,omitempty"`
+	Caption   string       `json:"caption,omitempty"`
+	Media     *eventMedia  `json:"media,omitempty"`
+	Quoted    *eventQuoted `json:"quoted,omitempty"`
+	Mentions  []string     `json:"mentions,omitempty"`
+	IsGroup   bool         `json:"isGroup"`
+	FromMe    bool         `json:"fromMe"`
+}
+
+// Returns a copy of an event payload with the given fields added
+func withFields(payload map[string]interface{}, fields map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(payload)+len(fields))
+	for k, v := range payload {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return merged
+}
+
+// Returns the media descriptor and caption of a media message
+func messageMedia(msg *waProto.Message) (*eventMedia, string) {
+	switch {
+	case msg.ImageMessage != nil:
+		m := msg.ImageMessage
+		return &eventMedia{Mimetype: m.GetMimetype(), Size: m.GetFileLength(), Width: m.GetWidth(), Height: m.GetHeight()}, m.GetCaption()
+	case msg.VideoMessage != nil:
+		m := msg.VideoMessage
+		return &eventMedia{Mimetype: m.GetMimetype(), Size: m.GetFileLength(), Seconds: m.GetSeconds(), Width: m.GetWidth(), Height: m.GetHeight()}, m.GetCaption()
+	case msg.AudioMessage != nil:
+		m := msg.AudioMessage
+		return &eventMedia{Mimetype: m.GetMimetype(), Size: m.GetFileLength(), Seconds: m.GetSeconds(), VoiceNote: m.GetPtt()}, ""
+	case msg.DocumentMessage != nil:
+		m := msg.DocumentMessage
+		return &eventMedia{Mimetype: m.GetMimetype(), Size: m.GetFileLength(), FileName: m.GetFileName()}, m.GetCaption()
+	case msg.StickerMessage != nil:
+		m := msg.StickerMessage
+		return &eventMedia{Mimetype: m.GetMimetype(), Size: m.GetFileLength(), Width: m.GetWidth(), Height: m.GetHeight()}, ""
+	}
+	return nil, ""
+}
+
+// Translates a message to the normalized event schema, mediaurl is the link to its saved media if any
+func normalizeMessage(evt *events.Message, mediaurl string) *eventMessage {
+	kind, text, contextInfo := messageContent(evt.Message)
+	msg := &eventMessage{
+		Id:        evt.Info.ID,
+		Chat:      evt.Info.Chat.ToNonAD().String(),
+		Sender:    evt.Info.Sender.ToNonAD().String(),
+		PushName:  evt.Info.PushName,
+		Timestamp: evt.Info.Timestamp,
+		Kind:      kind,
+		IsGroup:   evt.Info.IsGroup,
+		FromMe:    evt.Info.IsFromMe,
+	}
+	switch {
+	case evt.Message.GetProtocolMessage() != nil:
+		msg.Kind = "protocol"
+	case evt.Message.GetPollUpdateMessage() != nil:
+		msg.Kind = "pollVote"
+	case kind == "":
+		msg.Kind = "unknown"
+	}
+
+	if media, caption := messageMedia(evt.Message); media != nil {
+		media.Url = mediaurl
+		msg.Media = media
+		msg.Caption = caption
+	} else {
+		msg.Text = text
+	}
+
+	if contextInfo.GetStanzaId() != "" {
+		quotedKind, quotedText, _ := messageContent(contextInfo.GetQuotedMessage())
+		msg.Quoted = &eventQuoted{
+			Id:          contextInfo.GetStanzaId(),
+			Participant: contextInfo.GetParticipant(),
+			Kind:        quotedKind,
+			Text:        quotedText,
+		}
+	}
+	msg.Mentions = contextInfo.GetMentionedJid()
+	return msg
+}
+
+// Translates an event to the normalized event schema. Details gathered while handling the
+// event, such as receipt states or decrypted votes, are taken from the legacy payload.
+func normalizeEvent(rawEvt interface{}, postmap map[string]interface{}, mediaurl string) map[string]interface{} {
+	normalized := map[string]interface{}{
+		"version": eventSchemaVersion,
+		"type":    postmap["type"],
+	}
+	switch evt := rawEvt.(type) {
+	case *events.Message:
+		normalized["message"] = normalizeMessage(evt, mediaurl)
+		switch postmap["type"] {
+		case "MessageEdit":
+			normalized["edit"] = map[string]interface{}{"id": postmap["messageId"], "text": postmap["text"]}
+		case "MessageRevoke":
+			normalized["revoke"] = map[string]interface{}{"id": postmap["messageId"]}
+		case "PollVote":
+			normalized["pollVote"] = map[string]interface{}{"pollId": postmap["pollId"], "voter": postmap["voter"], "options": postmap["options"]}
+		case "InteractiveReply":
+			normalized["reply"] = map[string]interface{}{"type": postmap["replyType"], "id": postmap["selectedId"], "title": postmap["title"], "messageId": postmap["messageId"]}
+		}
+	case *events.Receipt:
+		normalized["receipt"] = map[string]interface{}{
+			"chat":      evt.Chat.ToNonAD().String(),
+			"sender":    evt.Sender.ToNonAD().String(),
+			"ids":       evt.MessageIDs,
+			"state":     postmap["state"],
+			"timestamp": evt.Timestamp,
+		}
+	case *events.Presence:
+		presence := map[string]interface{}{
+			"from":  evt.From.ToNonAD().String(),
+			"state": postmap["state"],
+		}
+		if !evt.LastSeen.IsZero() {
+			presence["lastSeen"] = evt.LastSeen
+		}
+		normalized["presence"] = presence
+	case *events.ChatPresence:
+		normalized["chatPresence"] = map[string]interface{}{
+			"chat":   evt.Chat.ToNonAD().String(),
+			"sender": evt.Sender.ToNonAD().String(),
+			"state":  string(evt.State),
+			"media":  string(evt.Media),
+		}
+	case *events.CallOffer, *events.CallAccept, *events.CallTerminate, *events.CallOfferNotice:
+		normalized["call"] = postmap["call"]
+	case *events.GroupInfo, *events.Picture:
+		if postmap["type"] == "GroupJoinRequest" {
+			normalized["joinRequest"] = postmap["request"]
 		} else {
-			log.Warn().Str("userid", strconv.Itoa(mycli.userID)).Msg("No webhook set for user")
+			normalized["groupUpdate"] = postmap["group"]
+		}
+	case *events.JoinedGroup:
+		normalized["joinedGroup"] = map[string]interface{}{"reason": evt.Reason, "type": evt.Type, "group": evt.GroupInfo}
+	case *events.HistorySync:
+		normalized["historySync"] = map[string]interface{}{
+			"syncType":      evt.Data.GetSyncType().String(),
+			"conversations": len(evt.Data.GetConversations()),
 		}
 	}
+	return normalized
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	testTime   = time.Date(2023, 6, 21, 12, 0, 0, 0, time.UTC)
+	testChat   = types.NewJID("5491155554444", types.DefaultUserServer)
+	testSender = types.NewADJID("5491155554444", 0, 3)
+	testGroup  = types.NewJID("120363025246125486", types.GroupServer)
+)
+
+func testMessageEvent(msg *waProto.Message) *events.Message {
+	return &events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{Chat: testChat, Sender: testSender},
+			ID:            "3EB0A1",
+			PushName:      "Ana",
+			Timestamp:     testTime,
+		},
+		Message: msg,
+	}
+}
+
+func assertJSON(t *testing.T, got interface{}, want string) {
+	t.Helper()
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotValue, wantValue interface{}
+	json.Unmarshal(data, &gotValue)
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("bad expected JSON: %v", err)
+	}
+	gotJSON, _ := json.Marshal(gotValue)
+	wantJSON, _ := json.Marshal(wantValue)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("got  %s\nwant %s", gotJSON, wantJSON)
+	}
+}
+
+func TestNormalizeMessage(t *testing.T) {
+	const base = `"id":"3EB0A1","chat":"5491155554444@s.whatsapp.net","sender":"5491155554444@s.whatsapp.net","pushName":"Ana","timestamp":"2023-06-21T12:00:00Z","isGroup":false,"fromMe":false`
+	tests := []struct {
+		name     string
+		msg      *waProto.Message
+		mediaurl string
+		want     string
+	}{
+		{
+			name: "conversation",
+			msg:  &waProto.Message{Conversation: proto.String("hello")},
+			want: `{` + base + `,"kind":"text","text":"hello"}`,
+		},
+		{
+			name: "reply with mentions",
+			msg: &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{
+				Text: proto.String("hi @5491155553935"),
+				ContextInfo: &waProto.ContextInfo{
+					StanzaId:      proto.String("3EB0A0"),
+					Participant:   proto.String("5491155553935@s.whatsapp.net"),
+					QuotedMessage: &waProto.Message{Conversation: proto.String("question")},
+					MentionedJid:  []string{"5491155553935@s.whatsapp.net"},
+				},
+			}},
+			want: `{` + base + `,"kind":"text","text":"hi @5491155553935",
+				"quoted":{"id":"3EB0A0","participant":"5491155553935@s.whatsapp.net","kind":"text","text":"question"},
+				"mentions":["5491155553935@s.whatsapp.net"]}`,
+		},
+		{
+			name: "image with caption",
+			msg: &waProto.Message{ImageMessage: &waProto.ImageMessage{
+				Caption:    proto.String("look"),
+				Mimetype:   proto.String("image/jpeg"),
+				FileLength: proto.Uint64(2048),
+				Width:      proto.Uint32(640),
+				Height:     proto.Uint32(480),
+			}},
+			mediaurl: "http://localhost:8080/media/user_1/3EB0A1.jpg",
+			want: `{` + base + `,"kind":"image","caption":"look",
+				"media":{"url":"http://localhost:8080/media/user_1/3EB0A1.jpg","mimetype":"image/jpeg","size":2048,"width":640,"height":480}}`,
+		},
+		{
+			name: "voice note",
+			msg: &waProto.Message{AudioMessage: &waProto.AudioMessage{
+				Mimetype:   proto.String("audio/ogg; codecs=opus"),
+				FileLength: proto.Uint64(4096),
+				Seconds:    proto.Uint32(3),
+				Ptt:        proto.Bool(true),
+			}},
+			want: `{` + base + `,"kind":"audio","media":{"mimetype":"audio/ogg; codecs=opus","size":4096,"seconds":3,"voiceNote":true}}`,
+		},
+		{
+			name: "protocol message",
+			msg:  &waProto.Message{ProtocolMessage: &waProto.ProtocolMessage{Type: waProto.ProtocolMessage_REVOKE.Enum()}},
+			want: `{` + base + `,"kind":"protocol"}`,
+		},
+		{
+			name: "poll vote",
+			msg:  &waProto.Message{PollUpdateMessage: &waProto.PollUpdateMessage{}},
+			want: `{` + base + `,"kind":"pollVote"}`,
+		},
+		{
+			name: "unknown",
+			msg:  &waProto.Message{},
+			want: `{` + base + `,"kind":"unknown"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertJSON(t, normalizeMessage(testMessageEvent(tt.msg), tt.mediaurl), tt.want)
+		})
+	}
+}
+
+func TestNormalizeEvent(t *testing.T) {
+	request := &joinRequestEvent{Group: testGroup.String(), Method: "invite_link", Timestamp: testTime, Requests: []string{"5491155553935@s.whatsapp.net"}}
+	tests := []struct {
+		name    string
+		evt     interface{}
+		postmap map[string]interface{}
+		want    string
+	}{
+		{
+			name:    "message edit",
+			evt:     testMessageEvent(&waProto.Message{ProtocolMessage: &waProto.ProtocolMessage{Type: waProto.ProtocolMessage_MESSAGE_EDIT.Enum()}}),
+			postmap: map[string]interface{}{"type": "MessageEdit", "messageId": "3EB09F", "text": "fixed"},
+			want: `{"version":1,"type":"MessageEdit","edit":{"id":"3EB09F","text":"fixed"},
+				"message":{"id":"3EB0A1","chat":"5491155554444@s.whatsapp.net","sender":"5491155554444@s.whatsapp.net","pushName":"Ana","timestamp":"2023-06-21T12:00:00Z","kind":"protocol","isGroup":false,"fromMe":false}}`,
+		},
+		{
+			name: "receipt",
+			evt: &events.Receipt{
+				MessageSource: types.MessageSource{Chat: testChat, Sender: testSender},
+				MessageIDs:    []types.MessageID{"3EB0A1", "3EB0A2"},
+				Timestamp:     testTime,
+				Type:          events.ReceiptTypeRead,
+			},
+			postmap: map[string]interface{}{"type": "ReadReceipt", "state": "Read"},
+			want: `{"version":1,"type":"ReadReceipt","receipt":{"chat":"5491155554444@s.whatsapp.net","sender":"5491155554444@s.whatsapp.net",
+				"ids":["3EB0A1","3EB0A2"],"state":"Read","timestamp":"2023-06-21T12:00:00Z"}}`,
+		},
+		{
+			name:    "presence with last seen",
+			evt:     &events.Presence{From: testSender, Unavailable: true, LastSeen: testTime},
+			postmap: map[string]interface{}{"type": "Presence", "state": "offline"},
+			want:    `{"version":1,"type":"Presence","presence":{"from":"5491155554444@s.whatsapp.net","state":"offline","lastSeen":"2023-06-21T12:00:00Z"}}`,
+		},
+		{
+			name:    "presence without last seen",
+			evt:     &events.Presence{From: testSender},
+			postmap: map[string]interface{}{"type": "Presence", "state": "online"},
+			want:    `{"version":1,"type":"Presence","presence":{"from":"5491155554444@s.whatsapp.net","state":"online"}}`,
+		},
+		{
+			name: "chat presence",
+			evt: &events.ChatPresence{
+				MessageSource: types.MessageSource{Chat: testChat, Sender: testSender},
+				State:         types.ChatPresenceComposing,
+				Media:         types.ChatPresenceMediaAudio,
+			},
+			postmap: map[string]interface{}{"type": "ChatPresence"},
+			want:    `{"version":1,"type":"ChatPresence","chatPresence":{"chat":"5491155554444@s.whatsapp.net","sender":"5491155554444@s.whatsapp.net","state":"composing","media":"audio"}}`,
+		},
+		{
+			name:    "call",
+			evt:     &events.CallTerminate{},
+			postmap: map[string]interface{}{"type": "Call", "call": &callInfo{Id: "8A4C", State: "terminate", Timestamp: testTime}},
+			want:    `{"version":1,"type":"Call","call":{"id":"8A4C","state":"terminate","from":"","creator":"","isVideo":false,"isGroup":false,"timestamp":"2023-06-21T12:00:00Z"}}`,
+		},
+		{
+			name:    "group join request",
+			evt:     &events.GroupInfo{JID: testGroup},
+			postmap: map[string]interface{}{"type": "GroupJoinRequest", "request": request},
+			want:    `{"version":1,"type":"GroupJoinRequest","joinRequest":{"group":"120363025246125486@g.us","method":"invite_link","timestamp":"2023-06-21T12:00:00Z","requests":["5491155553935@s.whatsapp.net"]}}`,
+		},
+		{
+			name:    "unhandled event keeps only type and version",
+			evt:     &events.Connected{},
+			postmap: map[string]interface{}{"type": "Connected"},
+			want:    `{"version":1,"type":"Connected"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertJSON(t, normalizeEvent(tt.evt, tt.postmap, ""), tt.want)
+		})
+	}
+}